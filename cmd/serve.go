@@ -12,9 +12,16 @@ import (
 
 	"github.com/joho/godotenv"
 	"github.com/segmentationfaulter/bookmarks-manager-api/internal/bookmarks"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/collections"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/mail"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/reminders"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/rules"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/shares"
 	"github.com/segmentationfaulter/bookmarks-manager-api/internal/tags"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/trash"
 	"github.com/segmentationfaulter/bookmarks-manager-api/internal/user"
 	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
 )
 
 func main() {
@@ -30,9 +37,14 @@ func main() {
 
 	server := http.Server{
 		Addr:    ":3000",
-		Handler: Mux(db),
+		Handler: utils.WithRequestId(Mux(db)),
 	}
 
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go trash.StartPurging(jobsCtx, db, trash.Retention())
+	go reminders.StartScheduler(jobsCtx, db, reminders.Notifiers(db))
+
 	quitSignal := make(chan os.Signal, 1)
 	signal.Notify(quitSignal, os.Interrupt, syscall.SIGTERM)
 
@@ -68,13 +80,110 @@ func Mux(db *sql.DB) *http.ServeMux {
 	// bookmarks endpoints
 	mux.HandleFunc("POST /api/bookmarks", bookmarks.CreateBookmarkHandler(db))
 	mux.HandleFunc("GET /api/bookmarks", bookmarks.GetBookmarksListHandler(db))
+	mux.HandleFunc("GET /api/bookmarks/export", bookmarks.ExportBookmarksHandler(db))
+	mux.HandleFunc("POST /api/bookmarks/bulk", bookmarks.BulkBookmarksHandler(db))
+	mux.HandleFunc("POST /api/bookmarks/suggest-tags", bookmarks.SuggestTagsHandler(db))
+	mux.HandleFunc("POST /api/bookmarks/tags", bookmarks.EditTagsHandler(db))
 	mux.HandleFunc("GET /api/bookmarks/{id}", bookmarks.GetBookmarkHandler(db))
 	mux.HandleFunc("PUT /api/bookmarks/{id}", bookmarks.UpdateBookmarkHandler(db))
+	mux.HandleFunc("PATCH /api/bookmarks/{id}", bookmarks.PatchBookmarkHandler(db))
 	mux.HandleFunc("DELETE /api/bookmarks/{id}", bookmarks.DeleteBookmarkHandler(db))
+	mux.HandleFunc("PUT /api/bookmarks/{id}/status", bookmarks.SetStatusHandler(db))
+	mux.HandleFunc("POST /api/bookmarks/{id}/favorite", bookmarks.SetFavoriteHandler(db))
+	mux.HandleFunc("DELETE /api/bookmarks/{id}/favorite", bookmarks.SetFavoriteHandler(db))
+	mux.HandleFunc("POST /api/bookmarks/{id}/archive", bookmarks.SetArchivedHandler(db))
+	mux.HandleFunc("DELETE /api/bookmarks/{id}/archive", bookmarks.SetArchivedHandler(db))
+	mux.HandleFunc("POST /api/bookmarks/{id}/tags", bookmarks.AddBookmarkTagsHandler(db))
+	mux.HandleFunc("DELETE /api/bookmarks/{id}/tags/{name...}", bookmarks.RemoveBookmarkTagHandler(db))
+	mux.HandleFunc("GET /api/bookmarks/{id}/revisions", bookmarks.GetRevisionsHandler(db))
+	mux.HandleFunc("POST /api/bookmarks/{id}/revisions/{rev}/restore", bookmarks.RestoreRevisionHandler(db))
+	mux.HandleFunc("GET /api/bookmarks/{id}/annotations", bookmarks.GetAnnotationsHandler(db))
+	mux.HandleFunc("POST /api/bookmarks/{id}/annotations", bookmarks.CreateAnnotationHandler(db))
+	mux.HandleFunc("PATCH /api/bookmarks/{id}/annotations/{annotationId}", bookmarks.UpdateAnnotationHandler(db))
+	mux.HandleFunc("DELETE /api/bookmarks/{id}/annotations/{annotationId}", bookmarks.DeleteAnnotationHandler(db))
+
+	mux.HandleFunc("GET /api/bookmarks/{id}/reminders", bookmarks.GetBookmarkRemindersHandler(db))
+	mux.HandleFunc("POST /api/bookmarks/{id}/reminders", bookmarks.CreateReminderHandler(db))
+
+	// annotations endpoints
+	mux.HandleFunc("GET /api/annotations", bookmarks.SearchAnnotationsHandler(db))
 
 	// tags endpoints
 	mux.HandleFunc("GET /api/tags", tags.GetTagsHandler(db))
+	mux.HandleFunc("POST /api/tags", tags.CreateTagHandler(db))
+	mux.HandleFunc("DELETE /api/tags", tags.DeleteUnusedTagsHandler(db))
+	mux.HandleFunc("GET /api/tags/tree", tags.GetTagTreeHandler(db))
+	mux.HandleFunc("GET /api/tags/autocomplete", tags.AutocompleteTagsHandler(db))
+	mux.HandleFunc("GET /api/tags/graph", tags.GetTagGraphHandler(db))
+	mux.HandleFunc("POST /api/tags/merge", tags.MergeTagsHandler(db))
+	mux.HandleFunc("PATCH /api/tags/{id}", tags.UpdateTagHandler(db))
 	mux.HandleFunc("DELETE /api/tags/{id}", tags.DeleteTagHandler(db))
+	mux.HandleFunc("GET /api/tags/{id}/bookmarks", bookmarks.GetTagBookmarksHandler(db))
+	mux.HandleFunc("POST /api/tags/{id}/move", tags.MoveTagHandler(db))
+	mux.HandleFunc("GET /api/tags/{id}/related", tags.GetRelatedTagsHandler(db))
+	mux.HandleFunc("GET /api/tags/{id}/aliases", tags.GetAliasesHandler(db))
+	mux.HandleFunc("POST /api/tags/{id}/aliases", tags.CreateAliasHandler(db))
+	mux.HandleFunc("DELETE /api/tags/{id}/aliases/{aliasId}", tags.DeleteAliasHandler(db))
+
+	// tag rules endpoints
+	mux.HandleFunc("GET /api/tag-rules", rules.GetRulesHandler(db))
+	mux.HandleFunc("POST /api/tag-rules", rules.CreateRuleHandler(db))
+	mux.HandleFunc("PATCH /api/tag-rules/{id}", rules.UpdateRuleHandler(db))
+	mux.HandleFunc("DELETE /api/tag-rules/{id}", rules.DeleteRuleHandler(db))
+	mux.HandleFunc("GET /api/tag-rules/{id}/preview", bookmarks.PreviewRuleHandler(db))
+	mux.HandleFunc("POST /api/tag-rules/{id}/apply", bookmarks.ApplyRuleHandler(db))
+
+	// collections endpoints
+	mux.HandleFunc("GET /api/collections", collections.GetCollectionsHandler(db))
+	mux.HandleFunc("POST /api/collections", collections.CreateCollectionHandler(db))
+	mux.HandleFunc("GET /api/collections/{id}", collections.GetCollectionHandler(db))
+	mux.HandleFunc("PATCH /api/collections/{id}", collections.RenameCollectionHandler(db))
+	mux.HandleFunc("DELETE /api/collections/{id}", collections.DeleteCollectionHandler(db))
+	mux.HandleFunc("POST /api/collections/{id}/move", collections.MoveCollectionHandler(db))
+	mux.HandleFunc("GET /api/collections/{id}/bookmarks", bookmarks.GetCollectionBookmarksHandler(db))
+	mux.HandleFunc("POST /api/collections/{id}/bookmarks", collections.FileBookmarksHandler(db))
+	mux.HandleFunc("DELETE /api/collections/{id}/bookmarks/{bookmarkId}", collections.UnfileBookmarkHandler(db))
+	mux.HandleFunc("GET /api/collections/{id}/members", collections.GetMembersHandler(db))
+	mux.HandleFunc("POST /api/collections/{id}/members", collections.AddMemberHandler(db))
+	mux.HandleFunc("PATCH /api/collections/{id}/members/{userId}", collections.UpdateMemberHandler(db))
+	mux.HandleFunc("DELETE /api/collections/{id}/members/{userId}", collections.RemoveMemberHandler(db))
+
+	// workspaces endpoints
+	mux.HandleFunc("GET /api/workspaces", workspaces.GetWorkspacesHandler(db))
+	mux.HandleFunc("POST /api/workspaces", workspaces.CreateWorkspaceHandler(db))
+	mux.HandleFunc("GET /api/workspaces/{id}", workspaces.GetWorkspaceHandler(db))
+	mux.HandleFunc("PATCH /api/workspaces/{id}", workspaces.RenameWorkspaceHandler(db))
+	mux.HandleFunc("DELETE /api/workspaces/{id}", workspaces.DeleteWorkspaceHandler(db))
+	mux.HandleFunc("GET /api/workspaces/{id}/members", workspaces.GetMembersHandler(db))
+	mux.HandleFunc("PATCH /api/workspaces/{id}/members/{userId}", workspaces.UpdateMemberHandler(db))
+	mux.HandleFunc("DELETE /api/workspaces/{id}/members/{userId}", workspaces.RemoveMemberHandler(db))
+	mux.HandleFunc("GET /api/workspaces/{id}/invites", workspaces.GetInvitesHandler(db))
+	mux.HandleFunc("POST /api/workspaces/{id}/invites", workspaces.CreateInviteHandler(db, mail.FromEnv()))
+	mux.HandleFunc("DELETE /api/workspaces/{id}/invites/{inviteId}", workspaces.RevokeInviteHandler(db))
+	mux.HandleFunc("GET /api/invites", workspaces.GetMyInvitesHandler(db))
+	mux.HandleFunc("POST /api/invites/{id}/accept", workspaces.AcceptInviteHandler(db))
+	mux.HandleFunc("POST /api/invites/{id}/decline", workspaces.DeclineInviteHandler(db))
+
+	// reminders endpoints
+	mux.HandleFunc("GET /api/reminders", reminders.GetRemindersHandler(db))
+	mux.HandleFunc("GET /api/reminders/due", reminders.GetDueRemindersHandler(db))
+	mux.HandleFunc("PATCH /api/reminders/{id}", reminders.UpdateReminderHandler(db))
+	mux.HandleFunc("DELETE /api/reminders/{id}", reminders.DeleteReminderHandler(db))
+	mux.HandleFunc("POST /api/reminders/{id}/dismiss", reminders.DismissReminderHandler(db))
+	mux.HandleFunc("GET /api/notifications", reminders.GetInboxHandler(db))
+	mux.HandleFunc("POST /api/notifications/{id}/read", reminders.MarkReadHandler(db))
+	mux.HandleFunc("DELETE /api/notifications/{id}", reminders.DeleteNotificationHandler(db))
+
+	// share links endpoints
+	mux.HandleFunc("GET /api/shares", shares.GetSharesHandler(db))
+	mux.HandleFunc("POST /api/shares", shares.CreateShareHandler(db))
+	mux.HandleFunc("DELETE /api/shares/{id}", shares.DeleteShareHandler(db))
+	mux.HandleFunc("GET /s/{token}", shares.SharedItemHandler(db))
+	mux.HandleFunc("POST /s/{token}", shares.SharedItemHandler(db))
+
+	// trash endpoints
+	mux.HandleFunc("GET /api/trash", trash.GetTrashHandler(db))
+	mux.HandleFunc("POST /api/trash/{id}/restore", trash.RestoreHandler(db))
 
 	return mux
 }
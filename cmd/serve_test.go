@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+type testClient struct {
+	t         *testing.T
+	server    *httptest.Server
+	token     string
+	workspace string
+}
+
+// newTestServer serves the API from a database in a fresh directory.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Chdir(t.TempDir())
+	t.Setenv("JWT_SIGNING_KEY", base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+
+	db, err := utils.InitDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	server := httptest.NewServer(utils.WithRequestId(Mux(db)))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// register creates a user and returns a client signed in as them.
+func register(t *testing.T, server *httptest.Server, username string) testClient {
+	client := testClient{t: t, server: server}
+	credentials := fmt.Sprintf(`{"username": %q, "email": "%s@example.com", "password": "password123"}`, username, username)
+	client.mustDo(http.StatusCreated, "POST", "/api/auth/register", credentials)
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	decode(t, client.mustDo(http.StatusOK, "POST", "/api/auth/login", credentials), &login)
+	client.token = login.Token
+	return client
+}
+
+// in returns a copy of the client acting on a workspace through the
+// X-Workspace-Id header.
+func (c testClient) in(workspace string) testClient {
+	c.workspace = workspace
+	return c
+}
+
+func (c testClient) do(method, path, body string) (int, string) {
+	c.t.Helper()
+	request, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.workspace != "" {
+		request.Header.Set("X-Workspace-Id", c.workspace)
+	}
+	if method == "PATCH" {
+		request.Header.Set("Content-Type", "application/merge-patch+json")
+	}
+
+	response, err := c.server.Client().Do(request)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return response.StatusCode, string(content)
+}
+
+func (c testClient) mustDo(status int, method, path, body string) string {
+	c.t.Helper()
+	got, content := c.do(method, path, body)
+	if got != status {
+		c.t.Fatalf("%s %s: expected %d, got %d: %s", method, path, status, got, content)
+	}
+	return content
+}
+
+// create posts body to path and returns the id of what it created.
+func (c testClient) create(path, body string) string {
+	c.t.Helper()
+	var created struct {
+		Id int `json:"id"`
+	}
+	decode(c.t, c.mustDo(http.StatusCreated, "POST", path, body), &created)
+	return fmt.Sprint(created.Id)
+}
+
+func decode(t *testing.T, content string, v any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(content), v); err != nil {
+		t.Fatalf("decoding %s: %v", content, err)
+	}
+}
+
+// workspaceData is what a user stored in one workspace. Everything has marker
+// in its text, so that any response leaking it elsewhere shows, except for
+// the share link, whose token shows instead.
+type workspaceData struct {
+	marker, owner                                                  string
+	bookmark, trashed, tag, annotation, reminder, collection, rule string
+	share, shareToken                                              string
+}
+
+// fill stores one of everything in the client's workspace.
+func fill(c testClient, marker string) workspaceData {
+	c.t.Helper()
+	data := workspaceData{marker: marker, owner: c.token}
+	data.bookmark = c.create("/api/bookmarks", `{"url": "https://`+marker+`.example.com/plans", "title": "`+marker+` plans", "tags": ["`+marker+`", "`+marker+`/nested"]}`)
+	c.mustDo(http.StatusOK, "PATCH", "/api/bookmarks/"+data.bookmark, `{"notes": "`+marker+` notes"}`)
+	data.trashed = c.create("/api/bookmarks", `{"url": "https://`+marker+`.example.com/old", "tags": ["`+marker+`"]}`)
+	c.mustDo(http.StatusNoContent, "DELETE", "/api/bookmarks/"+data.trashed, "")
+
+	var tags []struct {
+		Id   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	decode(c.t, c.mustDo(http.StatusOK, "GET", "/api/tags", ""), &tags)
+	for _, tag := range tags {
+		if tag.Name == marker {
+			data.tag = fmt.Sprint(tag.Id)
+		}
+	}
+
+	data.annotation = c.create("/api/bookmarks/"+data.bookmark+"/annotations", `{"quote": "`+marker+` quote", "comment": "`+marker+`"}`)
+	remindAt := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	data.reminder = c.create("/api/bookmarks/"+data.bookmark+"/reminders", `{"remind_at": "`+remindAt+`"}`)
+	data.collection = c.create("/api/collections", `{"name": "`+marker+` collection"}`)
+	c.mustDo(http.StatusNoContent, "POST", "/api/collections/"+data.collection+"/bookmarks", `{"bookmark_ids": [`+data.bookmark+`]}`)
+	data.rule = c.create("/api/tag-rules", `{"domain": "`+marker+`.example.com", "tags": ["`+marker+`"]}`)
+
+	var share struct {
+		Id    int    `json:"id"`
+		Token string `json:"token"`
+	}
+	decode(c.t, c.mustDo(http.StatusCreated, "POST", "/api/shares", `{"bookmark_id": `+data.bookmark+`}`), &share)
+	data.share, data.shareToken = fmt.Sprint(share.Id), share.Token
+	return data
+}
+
+// expectIsolated checks that a client can neither reach the data by id nor
+// find it in its lists. Reminders belong to users rather than workspaces, so
+// they're only checked against other users.
+func expectIsolated(t *testing.T, c testClient, data workspaceData) {
+	t.Helper()
+	requests := []struct{ method, path, body string }{
+		{"GET", "/api/bookmarks/" + data.bookmark, ""},
+		{"PATCH", "/api/bookmarks/" + data.bookmark, `{"title": "taken over"}`},
+		{"DELETE", "/api/bookmarks/" + data.bookmark, ""},
+		{"POST", "/api/bookmarks/" + data.bookmark + "/tags", `{"tags": ["taken-over"]}`},
+		{"GET", "/api/bookmarks/" + data.bookmark + "/revisions", ""},
+		{"POST", "/api/bookmarks/" + data.bookmark + "/revisions/1/restore", ""},
+		{"GET", "/api/bookmarks/" + data.bookmark + "/annotations", ""},
+		{"PATCH", "/api/bookmarks/" + data.bookmark + "/annotations/" + data.annotation, `{"comment": "taken over"}`},
+		{"GET", "/api/bookmarks/" + data.bookmark + "/reminders", ""},
+		{"GET", "/api/tags/" + data.tag + "/bookmarks", ""},
+		{"GET", "/api/tags/" + data.tag + "/related", ""},
+		{"GET", "/api/tags/" + data.tag + "/aliases", ""},
+		{"PATCH", "/api/tags/" + data.tag, `{"name": "taken-over"}`},
+		{"DELETE", "/api/tags/" + data.tag, ""},
+		{"GET", "/api/collections/" + data.collection, ""},
+		{"GET", "/api/collections/" + data.collection + "/bookmarks", ""},
+		{"GET", "/api/tag-rules/" + data.rule + "/preview", ""},
+		{"PATCH", "/api/tag-rules/" + data.rule, `{"enabled": false}`},
+		{"POST", "/api/trash/" + data.trashed + "/restore", ""},
+		{"DELETE", "/api/shares/" + data.share, ""},
+	}
+	lists := []string{
+		"/api/bookmarks",
+		"/api/bookmarks?search=" + data.marker,
+		"/api/bookmarks/export",
+		"/api/tags",
+		"/api/tags/tree",
+		"/api/tags/autocomplete?q=" + data.marker[:3],
+		"/api/tags/graph",
+		"/api/trash",
+		"/api/annotations?q=" + data.marker,
+		"/api/tag-rules",
+		"/api/collections",
+		"/api/shares",
+		"/api/shares?bookmark_id=" + data.bookmark,
+	}
+	if c.token != data.owner {
+		requests = append(requests, struct{ method, path, body string }{"PATCH", "/api/reminders/" + data.reminder, `{"recurrence": "daily"}`})
+		lists = append(lists, "/api/reminders")
+	}
+
+	for _, request := range requests {
+		if status, content := c.do(request.method, request.path, request.body); status != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404, got %d: %s", request.method, request.path, status, content)
+		}
+	}
+
+	if status, content := c.do("POST", "/api/shares", `{"bookmark_id": `+data.bookmark+`}`); status == http.StatusCreated {
+		t.Errorf("POST /api/shares: shared a bookmark of the other workspace: %s", content)
+	}
+
+	for _, path := range lists {
+		status, content := c.do("GET", path, "")
+		if status == http.StatusOK && (strings.Contains(content, data.marker) || strings.Contains(content, data.shareToken)) {
+			t.Errorf("GET %s leaks the other workspace: %s", path, content)
+		}
+		if status != http.StatusOK && status != http.StatusNotFound {
+			t.Errorf("GET %s: expected 200 or 404, got %d: %s", path, status, content)
+		}
+	}
+}
+
+func TestWorkspaceIsolation(t *testing.T) {
+	server := newTestServer(t)
+	alice := register(t, server, "alice")
+	bob := register(t, server, "bob")
+
+	team := alice.create("/api/workspaces", `{"name": "Team"}`)
+	personal := fill(alice, "personalsecret")
+	shared := fill(alice.in(team), "teamsecret")
+
+	t.Run("another user's personal workspace", func(t *testing.T) {
+		bob.t = t
+		expectIsolated(t, bob, personal)
+	})
+
+	t.Run("another user's workspace through the header", func(t *testing.T) {
+		bob.t = t
+		expectIsolated(t, bob, shared)
+		expectIsolated(t, bob.in(team), shared)
+		expectIsolated(t, bob.in(team), personal)
+	})
+
+	t.Run("own workspaces from each other", func(t *testing.T) {
+		alice.t = t
+		expectIsolated(t, alice, shared)
+		expectIsolated(t, alice.in(team), personal)
+	})
+
+	// Everything checked above must still be there for its owner.
+	alice.t = t
+	alice.mustDo(http.StatusOK, "GET", "/api/bookmarks/"+personal.bookmark, "")
+	alice.in(team).mustDo(http.StatusOK, "GET", "/api/bookmarks/"+shared.bookmark, "")
+	alice.mustDo(http.StatusOK, "GET", "/api/tags/"+personal.tag+"/related", "")
+	for path, want := range map[string]string{
+		"/api/bookmarks?search=" + personal.marker: personal.marker,
+		"/api/shares": personal.shareToken,
+	} {
+		if content := alice.mustDo(http.StatusOK, "GET", path, ""); !strings.Contains(content, want) {
+			t.Errorf("GET %s doesn't find its own workspace's data: %s", path, content)
+		}
+	}
+}
+
+func TestAcceptInviteNeedsToken(t *testing.T) {
+	server := newTestServer(t)
+	alice := register(t, server, "alice")
+	// Anyone can register with the invited address, which proves nothing.
+	bob := register(t, server, "bob")
+
+	team := alice.create("/api/workspaces", `{"name": "Team"}`)
+	var invite struct {
+		Id    int    `json:"id"`
+		Token string `json:"token"`
+	}
+	decode(t, alice.mustDo(http.StatusCreated, "POST", "/api/workspaces/"+team+"/invites", `{"email": "bob@example.com"}`), &invite)
+	if invite.Token == "" {
+		t.Fatal("the invite came without a token")
+	}
+
+	accept := fmt.Sprintf("/api/invites/%d/accept", invite.Id)
+	bob.mustDo(http.StatusBadRequest, "POST", accept, `{}`)
+	bob.mustDo(http.StatusNotFound, "POST", accept, `{"token": "guessed"}`)
+	bob.mustDo(http.StatusNotFound, "POST", fmt.Sprintf("/api/invites/%d/decline", invite.Id), `{"token": "guessed"}`)
+	bob.in(team).mustDo(http.StatusNotFound, "GET", "/api/bookmarks", "")
+
+	bob.mustDo(http.StatusOK, "POST", accept, `{"token": "`+invite.Token+`"}`)
+	bob.in(team).mustDo(http.StatusOK, "GET", "/api/bookmarks", "")
+	bob.mustDo(http.StatusNotFound, "POST", accept, `{"token": "`+invite.Token+`"}`)
+}
+
+func TestExportIncludesAnnotations(t *testing.T) {
+	server := newTestServer(t)
+	alice := register(t, server, "alice")
+
+	annotated := alice.create("/api/bookmarks", `{"url": "https://example.com/annotated", "tags": ["go"]}`)
+	alice.create("/api/bookmarks/"+annotated+"/annotations", `{"quote": "a quote", "selector": {"type": "TextPositionSelector", "start": 4, "end": 11}, "comment": "a comment"}`)
+	archived := alice.create("/api/bookmarks", `{"url": "https://example.com/archived"}`)
+	alice.mustDo(http.StatusOK, "POST", "/api/bookmarks/"+archived+"/archive", "")
+	trashed := alice.create("/api/bookmarks", `{"url": "https://example.com/trashed"}`)
+	alice.mustDo(http.StatusNoContent, "DELETE", "/api/bookmarks/"+trashed, "")
+
+	var export struct {
+		Bookmarks []struct {
+			Url         string   `json:"url"`
+			Tags        []string `json:"tags"`
+			Annotations []struct {
+				Quote    string          `json:"quote"`
+				Selector json.RawMessage `json:"selector"`
+				Comment  string          `json:"comment"`
+			} `json:"annotations"`
+		} `json:"bookmarks"`
+	}
+	decode(t, alice.mustDo(http.StatusOK, "GET", "/api/bookmarks/export", ""), &export)
+
+	if len(export.Bookmarks) != 2 {
+		t.Fatalf("expected the annotated and the archived bookmark, got %+v", export.Bookmarks)
+	}
+	first := export.Bookmarks[0]
+	if first.Url != "https://example.com/annotated" || len(first.Tags) != 1 || len(first.Annotations) != 1 {
+		t.Fatalf("unexpected first bookmark: %+v", first)
+	}
+	if annotation := first.Annotations[0]; annotation.Quote != "a quote" || annotation.Comment != "a comment" || len(annotation.Selector) == 0 {
+		t.Errorf("unexpected annotation: %+v", annotation)
+	}
+	if second := export.Bookmarks[1]; second.Annotations == nil || len(second.Annotations) != 0 {
+		t.Errorf("expected an empty list of annotations, got %+v", second.Annotations)
+	}
+}
+
+func TestRulesOnlyTagNewURLsAndTitles(t *testing.T) {
+	server := newTestServer(t)
+	alice := register(t, server, "alice")
+	alice.create("/api/tag-rules", `{"domain": "example.com", "tags": ["auto"]}`)
+
+	tagsOf := func(content string) string {
+		var bookmark struct {
+			Tags []string `json:"tags"`
+		}
+		decode(t, content, &bookmark)
+		return strings.Join(bookmark.Tags, ",")
+	}
+	expectTags := func(content, want string) {
+		t.Helper()
+		if got := tagsOf(content); got != want {
+			t.Errorf("expected tags %q, got %q", want, got)
+		}
+	}
+
+	id := alice.create("/api/bookmarks", `{"url": "https://example.com/a", "tags": ["manual"]}`)
+	path := "/api/bookmarks/" + id
+	expectTags(alice.mustDo(http.StatusOK, "GET", path, ""), "auto,manual")
+
+	// Tags set explicitly stay as they are, and so do they when other fields change.
+	expectTags(alice.mustDo(http.StatusOK, "PATCH", path, `{"tags": ["manual"]}`), "manual")
+	expectTags(alice.mustDo(http.StatusOK, "PATCH", path, `{"notes": "read later"}`), "manual")
+	expectTags(alice.mustDo(http.StatusOK, "PATCH", path, `{"title": "Renamed", "tags": ["manual"]}`), "manual")
+	expectTags(alice.mustDo(http.StatusOK, "PUT", path, `{"url": "https://example.com/a", "title": "Renamed", "tags": ["manual"]}`), "manual")
+
+	// A new title or URL runs the rules again.
+	expectTags(alice.mustDo(http.StatusOK, "PATCH", path, `{"title": "Renamed again"}`), "auto,manual")
+	alice.mustDo(http.StatusOK, "PATCH", path, `{"tags": []}`)
+	expectTags(alice.mustDo(http.StatusOK, "PUT", path, `{"url": "https://example.com/b", "tags": []}`), "auto")
+}
+
+func TestBulkTagEdits(t *testing.T) {
+	server := newTestServer(t)
+	alice := register(t, server, "alice")
+	first := alice.create("/api/bookmarks", `{"url": "https://example.com/1", "tags": ["go"]}`)
+	second := alice.create("/api/bookmarks", `{"url": "https://example.com/2"}`)
+	ids := `[` + first + `, ` + second + `]`
+
+	type response struct {
+		Succeeded int `json:"succeeded"`
+		Unchanged int `json:"unchanged"`
+		Failed    int `json:"failed"`
+		Results   []struct {
+			Id     int    `json:"id"`
+			Status string `json:"status"`
+		} `json:"results"`
+	}
+	expect := func(content string, succeeded, unchanged, failed int) {
+		t.Helper()
+		var got response
+		decode(t, content, &got)
+		if got.Succeeded != succeeded || got.Unchanged != unchanged || got.Failed != failed {
+			t.Errorf("expected %d ok, %d unchanged and %d failed, got %s", succeeded, unchanged, failed, content)
+		}
+	}
+	version := func(id string) string {
+		t.Helper()
+		var bookmark struct {
+			Version int `json:"version"`
+		}
+		decode(t, alice.mustDo(http.StatusOK, "GET", "/api/bookmarks/"+id, ""), &bookmark)
+		return fmt.Sprint(bookmark.Version)
+	}
+
+	// Adding a tag a bookmark already has leaves it alone, version included.
+	before := version(first)
+	expect(alice.mustDo(http.StatusOK, "POST", "/api/bookmarks/bulk", `{"action": "add_tags", "ids": `+ids+`, "tags": ["go"]}`), 1, 1, 0)
+	if after := version(first); after != before {
+		t.Errorf("expected the untouched bookmark to stay at version %s, got %s", before, after)
+	}
+	expect(alice.mustDo(http.StatusOK, "POST", "/api/bookmarks/bulk", `{"action": "remove_tags", "ids": `+ids+`, "tags": ["go"], "dry_run": true}`), 2, 0, 0)
+
+	// The tag edits endpoint runs the same action, aliases included.
+	tag := ""
+	var tags []struct {
+		Id   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	decode(t, alice.mustDo(http.StatusOK, "GET", "/api/tags", ""), &tags)
+	for _, candidate := range tags {
+		if candidate.Name == "go" {
+			tag = fmt.Sprint(candidate.Id)
+		}
+	}
+	alice.create("/api/tags/"+tag+"/aliases", `{"alias": "golang"}`)
+	expect(alice.mustDo(http.StatusOK, "POST", "/api/bookmarks/tags", `{"ids": `+ids+`, "add": ["rust"], "remove": ["golang"]}`), 2, 0, 0)
+	alice.mustDo(http.StatusBadRequest, "POST", "/api/bookmarks/tags", `{"ids": `+ids+`, "add": ["go"], "remove": ["golang"]}`)
+	expect(alice.mustDo(http.StatusOK, "POST", "/api/bookmarks/bulk", `{"action": "edit_tags", "filter": {"tags": ["rust"]}, "remove": ["rust"]}`), 2, 0, 0)
+}
+
+func TestReusingTrashedTagLeavesItsBookmarks(t *testing.T) {
+	server := newTestServer(t)
+	alice := register(t, server, "alice")
+	old := alice.create("/api/bookmarks", `{"url": "https://example.com/old", "tags": ["todo"]}`)
+
+	var tags []struct {
+		Id int `json:"id"`
+	}
+	decode(t, alice.mustDo(http.StatusOK, "GET", "/api/tags", ""), &tags)
+	alice.mustDo(http.StatusNoContent, "DELETE", fmt.Sprintf("/api/tags/%d", tags[0].Id), "")
+
+	type bookmark struct {
+		Version int      `json:"version"`
+		Tags    []string `json:"tags"`
+	}
+	var before, after, reused bookmark
+	decode(t, alice.mustDo(http.StatusOK, "GET", "/api/bookmarks/"+old, ""), &before)
+
+	id := alice.create("/api/bookmarks", `{"url": "https://example.com/new", "tags": ["todo"]}`)
+	decode(t, alice.mustDo(http.StatusOK, "GET", "/api/bookmarks/"+id, ""), &reused)
+	if strings.Join(reused.Tags, ",") != "todo" {
+		t.Errorf("expected the new bookmark to be tagged todo, got %q", reused.Tags)
+	}
+
+	decode(t, alice.mustDo(http.StatusOK, "GET", "/api/bookmarks/"+old, ""), &after)
+	if len(after.Tags) != 0 || after.Version != before.Version {
+		t.Errorf("expected the trashed tag's old bookmark to stay untagged at version %d, got %q at version %d",
+			before.Version, after.Tags, after.Version)
+	}
+}
+
+// expectUnauthorized checks that anonymous requests are refused before
+// their body or path is looked at.
+func expectUnauthorized(t *testing.T, server *httptest.Server, requests [][3]string) {
+	t.Helper()
+	anonymous := testClient{t: t, server: server}
+	for _, request := range requests {
+		anonymous.mustDo(http.StatusUnauthorized, request[0], request[1], request[2])
+	}
+}
+
+func TestBookmarkTagEditsAuthenticateFirst(t *testing.T) {
+	expectUnauthorized(t, newTestServer(t), [][3]string{
+		{"POST", "/api/bookmarks/1/tags", `{"tags": []}`},
+		{"POST", "/api/bookmarks/1/tags", `not json`},
+		{"DELETE", "/api/bookmarks/1/tags/%20", ""},
+		{"POST", "/api/bookmarks/tags", `{"ids": []}`},
+	})
+}
+
+func TestBookmarkStateAuthenticatesFirst(t *testing.T) {
+	expectUnauthorized(t, newTestServer(t), [][3]string{
+		{"PUT", "/api/bookmarks/1/status", `{"status": "done"}`},
+		{"PUT", "/api/bookmarks/1/status", `not json`},
+		{"POST", "/api/bookmarks/1/favorite", ""},
+		{"DELETE", "/api/bookmarks/1/archive", ""},
+	})
+}
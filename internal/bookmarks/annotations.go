@@ -0,0 +1,580 @@
+package bookmarks
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/collections"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const (
+	MAX_QUOTE_LENGTH   = 5000
+	MAX_COMMENT_LENGTH = 5000
+)
+
+// Selectors of the W3C Web Annotation Data Model
+// (https://www.w3.org/TR/annotation-model/#selectors) that locate a highlight
+// in the bookmarked page. They're stored as sent, once their required
+// properties are checked.
+const (
+	SELECTOR_TEXT_QUOTE    = "TextQuoteSelector"
+	SELECTOR_TEXT_POSITION = "TextPositionSelector"
+	SELECTOR_CSS           = "CssSelector"
+	SELECTOR_XPATH         = "XPathSelector"
+	SELECTOR_FRAGMENT      = "FragmentSelector"
+	SELECTOR_RANGE         = "RangeSelector"
+)
+
+var selectorTypes = []string{
+	SELECTOR_TEXT_QUOTE,
+	SELECTOR_TEXT_POSITION,
+	SELECTOR_CSS,
+	SELECTOR_XPATH,
+	SELECTOR_FRAGMENT,
+	SELECTOR_RANGE,
+}
+
+var patchableAnnotationFields = []string{"quote", "selector", "comment"}
+
+const (
+	CREATE_ANNOTATION = `INSERT INTO annotations (bookmark_id, user_id, quote, selector, comment) VALUES(?, ?, ?, ?, ?)`
+	UPDATE_ANNOTATION = `UPDATE annotations SET quote = ?, selector = ?, comment = ? WHERE id = ? AND bookmark_id = ?`
+	DELETE_ANNOTATION = `DELETE FROM annotations WHERE id = ? AND bookmark_id = ?`
+)
+
+// annotationColumns lists the columns scanned by scanAnnotation, for
+// annotations aliased as a.
+const annotationColumns = `a.id, a.bookmark_id, a.user_id, a.quote, a.selector, a.comment, a.created_at, a.updated_at`
+
+// Annotation highlights a passage of a bookmarked page, comments on it, or
+// both.
+type Annotation struct {
+	Id         int `json:"id"`
+	BookmarkId int `json:"bookmark_id"`
+	// AuthorId is the user who created the annotation.
+	AuthorId int    `json:"author_id"`
+	Quote    string `json:"quote,omitempty"`
+	// Selector locates the quote in the page, following the W3C Web
+	// Annotation Data Model.
+	Selector  json.RawMessage `json:"selector,omitempty"`
+	Comment   string          `json:"comment,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// AnnotationMatch is an annotation found by searching, with the bookmark it
+// belongs to.
+type AnnotationMatch struct {
+	Annotation
+	BookmarkUrl   string `json:"bookmark_url"`
+	BookmarkTitle string `json:"bookmark_title,omitempty"`
+}
+
+type annotationRequest struct {
+	Quote    string          `json:"quote"`
+	Selector json.RawMessage `json:"selector"`
+	Comment  string          `json:"comment"`
+}
+
+// selector holds the properties checked on a W3C selector. Any other
+// property is kept as it was sent.
+type selector struct {
+	Type          string          `json:"type"`
+	Exact         *string         `json:"exact"`
+	Prefix        *string         `json:"prefix"`
+	Suffix        *string         `json:"suffix"`
+	Start         *int            `json:"start"`
+	End           *int            `json:"end"`
+	Value         *string         `json:"value"`
+	StartSelector json.RawMessage `json:"startSelector"`
+	EndSelector   json.RawMessage `json:"endSelector"`
+	RefinedBy     json.RawMessage `json:"refinedBy"`
+}
+
+// GetAnnotationsHandler lists a bookmark's annotations in reading order, as
+// far as their selectors tell, then in the order they were made.
+func GetAnnotationsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_VIEWER); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		annotations, err := getAnnotations(db, `a.bookmark_id = ?`, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, annotations)
+	}
+}
+
+// CreateAnnotationHandler adds an annotation to a bookmark. A quote left out
+// is taken from a TextQuoteSelector.
+func CreateAnnotationHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[annotationRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if errs := request.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if _, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_EDITOR); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, CREATE_ANNOTATION, append([]any{id, userId}, request.values()...)...)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		annotationId, err := result.LastInsertId()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeAnnotation(w, r, db, id, strconv.FormatInt(annotationId, 10), http.StatusCreated)
+	}
+}
+
+// UpdateAnnotationHandler applies a JSON Merge Patch to an annotation.
+func UpdateAnnotationHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		annotationId, err := utils.PathId(r, "annotationId", "annotation")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		patch, err := utils.DecodeRequestBody[map[string]json.RawMessage](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_EDITOR); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		existing, err := findAnnotation(db, id, annotationId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, errs := applyAnnotationPatch(existing, *patch)
+		errs.Merge(request.validate())
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if _, err := utils.Exec(db, UPDATE_ANNOTATION, append(request.values(), annotationId, id)...); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeAnnotation(w, r, db, id, annotationId, http.StatusOK)
+	}
+}
+
+func DeleteAnnotationHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		annotationId, err := utils.PathId(r, "annotationId", "annotation")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_EDITOR); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, DELETE_ANNOTATION, annotationId, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Annotation not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SearchAnnotationsHandler lists the annotations on the current workspace's
+// bookmarks whose quote or comment contains q, newest first and paginated
+// like the bookmarks list. Bookmarks in the trash are left out.
+func SearchAnnotationsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		queryParams := getQueryParams(r)
+		pattern := "%" + r.URL.Query().Get("q") + "%"
+
+		matches, err := utils.FindMany(
+			func() (*sql.Stmt, *sql.Rows, error) {
+				stmt, err := db.Prepare(`
+					SELECT ` + annotationColumns + `, b.url, b.title
+					FROM annotations a
+					JOIN bookmarks b ON a.bookmark_id = b.id
+					WHERE b.workspace_id = ? AND b.deleted_at IS NULL
+					  AND (a.quote LIKE ? OR a.comment LIKE ?)
+					ORDER BY a.created_at DESC, a.id DESC
+					LIMIT ? OFFSET ?`)
+				if err != nil {
+					return nil, nil, err
+				}
+				rows, err := stmt.Query(workspaceId, pattern, pattern,
+					queryParams.limit, queryParams.limit*(queryParams.page-1))
+				return stmt, rows, err
+			},
+			annotationMatchesScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, matches)
+	}
+}
+
+// validate normalizes the request in place and returns every rule it breaks.
+func (a *annotationRequest) validate() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	if isJSONNull(a.Selector) {
+		a.Selector = nil
+	}
+	if a.Selector != nil {
+		exact, err := validateSelector(a.Selector)
+		if err != nil {
+			errs.Add("selector", err.Error())
+		} else if a.Quote == "" {
+			a.Quote = exact
+		}
+	}
+
+	if utf8.RuneCountInString(a.Quote) > MAX_QUOTE_LENGTH {
+		errs.Add("quote", fmt.Sprintf("Quote should be no longer than %d characters", MAX_QUOTE_LENGTH))
+	}
+
+	a.Comment = strings.TrimSpace(a.Comment)
+	if utf8.RuneCountInString(a.Comment) > MAX_COMMENT_LENGTH {
+		errs.Add("comment", fmt.Sprintf("Comment should be no longer than %d characters", MAX_COMMENT_LENGTH))
+	}
+
+	if strings.TrimSpace(a.Quote) == "" && a.Comment == "" {
+		errs.Add("quote", "An annotation needs a quote or a comment")
+	}
+
+	return errs
+}
+
+// values returns the quote, selector and comment to store, with NULL for
+// those left empty.
+func (a *annotationRequest) values() []any {
+	var quote, selector, comment any
+	if a.Quote != "" {
+		quote = a.Quote
+	}
+	if a.Selector != nil {
+		var compact bytes.Buffer
+		if json.Compact(&compact, a.Selector) == nil {
+			selector = compact.String()
+		}
+	}
+	if a.Comment != "" {
+		comment = a.Comment
+	}
+	return []any{quote, selector, comment}
+}
+
+// validateSelector checks the properties a selector of its type requires,
+// including those of the selectors it nests, and returns the text quoted by a
+// TextQuoteSelector.
+func validateSelector(raw json.RawMessage) (string, error) {
+	var s selector
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", fmt.Errorf("Selector should be an object")
+	}
+
+	var exact string
+	switch s.Type {
+	case SELECTOR_TEXT_QUOTE:
+		if s.Exact == nil || *s.Exact == "" {
+			return "", fmt.Errorf("%s requires exact", s.Type)
+		}
+		exact = *s.Exact
+	case SELECTOR_TEXT_POSITION:
+		if s.Start == nil || s.End == nil || *s.Start < 0 || *s.End < *s.Start {
+			return "", fmt.Errorf("%s requires start and end, with 0 <= start <= end", s.Type)
+		}
+	case SELECTOR_CSS, SELECTOR_XPATH, SELECTOR_FRAGMENT:
+		if s.Value == nil || *s.Value == "" {
+			return "", fmt.Errorf("%s requires value", s.Type)
+		}
+	case SELECTOR_RANGE:
+		if s.StartSelector == nil || s.EndSelector == nil {
+			return "", fmt.Errorf("%s requires startSelector and endSelector", s.Type)
+		}
+		for _, nested := range []json.RawMessage{s.StartSelector, s.EndSelector} {
+			if _, err := validateSelector(nested); err != nil {
+				return "", err
+			}
+		}
+	default:
+		return "", fmt.Errorf("Selector type should be one of: %s", strings.Join(selectorTypes, ", "))
+	}
+
+	if s.RefinedBy != nil {
+		if _, err := validateSelector(s.RefinedBy); err != nil {
+			return "", err
+		}
+	}
+
+	return exact, nil
+}
+
+// applyAnnotationPatch applies a JSON Merge Patch (RFC 7386) document to an
+// existing annotation, the same way applyMergePatch does for bookmarks.
+func applyAnnotationPatch(existing *Annotation, patch map[string]json.RawMessage) (*annotationRequest, utils.FieldErrors) {
+	errs := utils.FieldErrors{}
+	result := &annotationRequest{Quote: existing.Quote, Selector: existing.Selector, Comment: existing.Comment}
+
+	for field := range patch {
+		if !slices.Contains(patchableAnnotationFields, field) {
+			errs.Add(field, "Unknown or read-only field")
+		}
+	}
+
+	stringFields := map[string]*string{
+		"quote":   &result.Quote,
+		"comment": &result.Comment,
+	}
+	for field, target := range stringFields {
+		value, ok := patch[field]
+		if !ok {
+			continue
+		}
+		if isJSONNull(value) {
+			*target = ""
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			errs.Add(field, "Should be a string or null")
+		}
+	}
+
+	if value, ok := patch["selector"]; ok {
+		result.Selector = value
+	}
+
+	return result, errs
+}
+
+func writeAnnotation(w http.ResponseWriter, r *http.Request, db *sql.DB, bookmarkId, id string, status int) {
+	annotation, err := findAnnotation(db, bookmarkId, id)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, status, annotation)
+}
+
+func findAnnotation(db *sql.DB, bookmarkId, id string) (*Annotation, error) {
+	annotations, err := getAnnotations(db, `a.bookmark_id = ? AND a.id = ?`, bookmarkId, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(annotations) == 0 {
+		return nil, utils.NotFound("Annotation not found")
+	}
+	return &annotations[0], nil
+}
+
+// getAnnotations lists the annotations matching filter, a condition over
+// annotations aliased as a. Those with a TextPositionSelector come first, in
+// the order they appear in the page.
+func getAnnotations(execer utils.Execer, filter string, args ...any) ([]Annotation, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := execer.Prepare(`
+				SELECT ` + annotationColumns + `
+				FROM annotations a
+				WHERE ` + filter + `
+				ORDER BY json_extract(a.selector, '$.start') NULLS LAST, a.created_at, a.id`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(args...)
+			return stmt, rows, err
+		},
+		annotationsScanner,
+	)
+}
+
+func annotationsScanner(rows *sql.Rows) ([]Annotation, error) {
+	result := []Annotation{}
+
+	for rows.Next() {
+		annotation, err := scanAnnotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, annotation)
+	}
+
+	return result, rows.Err()
+}
+
+func annotationMatchesScanner(rows *sql.Rows) ([]AnnotationMatch, error) {
+	result := []AnnotationMatch{}
+
+	for rows.Next() {
+		var match AnnotationMatch
+		var title sql.NullString
+		annotation, err := scanAnnotation(rows, &match.BookmarkUrl, &title)
+		if err != nil {
+			return nil, err
+		}
+		match.Annotation = annotation
+		match.BookmarkTitle = title.String
+		result = append(result, match)
+	}
+
+	return result, rows.Err()
+}
+
+// scanAnnotation scans the columns listed by annotationColumns, followed by
+// any extra ones.
+func scanAnnotation(rows *sql.Rows, extra ...any) (Annotation, error) {
+	var annotation Annotation
+	var quote, selector, comment sql.NullString
+	err := rows.Scan(append([]any{
+		&annotation.Id,
+		&annotation.BookmarkId,
+		&annotation.AuthorId,
+		&quote,
+		&selector,
+		&comment,
+		&annotation.CreatedAt,
+		&annotation.UpdatedAt,
+	}, extra...)...)
+	if err != nil {
+		return annotation, err
+	}
+
+	annotation.Quote = quote.String
+	annotation.Comment = comment.String
+	if selector.Valid {
+		annotation.Selector = json.RawMessage(selector.String)
+	}
+	return annotation, nil
+}
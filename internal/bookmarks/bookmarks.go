@@ -5,33 +5,74 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/collections"
 	"github.com/segmentationfaulter/bookmarks-manager-api/internal/tags"
 	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
 )
 
+const MAX_PAGE_LIMIT = 100
+
+var sortableColumns = []string{"created_at", "updated_at", "title", "url", "status", "read_at"}
+
 type BookmarksQueryParams struct {
-	page   int
-	limit  int
-	tags   []string
-	search string
-	sort   string
-	order  string
+	page  int
+	limit int
+	tags  []string
+	// descendants extends the tags filter to the tags nested under them.
+	descendants bool
+	search      string
+	status      string
+	favorite    *bool
+	archived    string
+	collection  string
+	tag         string
+	sort        string
+	order       string
 }
 
 type Bookmark struct {
-	Id          int       `json:"id"`
-	Url         string    `json:"url"`
-	Title       string    `json:"title,omitempty"`
-	Description string    `json:"description,omitempty"`
-	Notes       string    `json:"notes,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	Id           int        `json:"id"`
+	Url          string     `json:"url"`
+	Title        string     `json:"title,omitempty"`
+	Description  string     `json:"description,omitempty"`
+	Notes        string     `json:"notes,omitempty"`
+	Status       string     `json:"status"`
+	IsFavorite   bool       `json:"is_favorite"`
+	IsArchived   bool       `json:"is_archived"`
+	ReadAt       *time.Time `json:"read_at,omitempty"`
+	CollectionId *int       `json:"collection_id"`
+	Version      int        `json:"version"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// bookmarkColumns lists the columns scanned by Bookmark.scanFields, for
+// bookmarks aliased as b.
+const bookmarkColumns = `b.id, b.url, b.title, b.description, b.notes, b.status, b.is_favorite, b.is_archived, b.read_at,
+	(SELECT collection_id FROM collection_bookmarks WHERE bookmark_id = b.id), b.version, b.created_at, b.updated_at`
+
+func (b *Bookmark) scanFields() []any {
+	return []any{
+		&b.Id,
+		&b.Url,
+		&b.Title,
+		&b.Description,
+		&b.Notes,
+		&b.Status,
+		&b.IsFavorite,
+		&b.IsArchived,
+		&b.ReadAt,
+		&b.CollectionId,
+		&b.Version,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+	}
 }
 
 type BookmarkWithTag struct {
@@ -42,235 +83,342 @@ type BookmarkWithTag struct {
 type BookmarkWithTags struct {
 	Bookmark
 	Tags []string `json:"tags"`
+	// TagDetails holds the full tags when asked for with ?expand=tags.
+	TagDetails []tags.Tag `json:"tag_details,omitzero"`
+	// SuggestedTags holds tag suggestions when asked for with
+	// ?expand=suggested_tags.
+	SuggestedTags []tags.Suggestion `json:"suggested_tags,omitzero"`
+	ETag          string            `json:"etag"`
 }
 
 func GetBookmarksListHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userId, httpStatus, err := utils.IsAuthenticated(r)
+		userId, err := utils.IsAuthenticated(r)
 		if err != nil {
-			http.Error(w, err.Error(), httpStatus)
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 		queryParams := getQueryParams(r)
 		bookmarks, err := utils.FindMany(
-			bookmarksListQueryRunner(db, string(userId), queryParams),
+			bookmarksListQueryRunner(db, workspaceId, queryParams),
 			bookmarksScanner,
 		)
 		if err != nil {
-			http.Error(w, "Error getting bookmarks: "+err.Error(), http.StatusBadRequest)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(normalizeBookmarks(bookmarks))
+		writeBookmarks(w, r, db, normalizeBookmarks(bookmarks))
 	}
 }
 
 func GetBookmarkHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		if _, err := strconv.Atoi(id); err != nil || id == "" {
-			http.Error(w, "Invalid bookmark ID", http.StatusBadRequest)
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		userId, httpStatus, err := utils.IsAuthenticated(r)
+		userId, err := utils.IsAuthenticated(r)
 		if err != nil {
-			http.Error(w, err.Error(), httpStatus)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		bookmarks, err := utils.FindMany(
-			bookmarkByIdQueryRunner(db, string(userId), id),
-			bookmarksScanner,
-		)
+		workspaceId, err := workspaces.Current(db, r, userId)
 		if err != nil {
-			http.Error(w, "Error getting bookmark "+err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		bookmarkWorkspaceId, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_VIEWER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		bookmark, err := getBookmark(db, bookmarkWorkspaceId, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", bookmark.ETag)
+		if utils.IfNoneMatch(r, bookmark.ETag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if err := expandTags(db, r, []*BookmarkWithTags{bookmark}); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if err := expandSuggestedTags(db, r, bookmarkWorkspaceId, bookmark); err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(normalizeBookmarks(bookmarks))
+		utils.WriteJSON(w, http.StatusOK, bookmark)
 	}
 }
 
 func CreateBookmarkHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userId, httpStatus, err := utils.IsAuthenticated(r)
+		userId, err := utils.IsAuthenticated(r)
 		if err != nil {
-			http.Error(w, err.Error(), httpStatus)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		bookmark, err := utils.DecodeRequestBody[struct {
-			Bookmark
-			Tags []string
-		}](r)
+		workspaceId, err := workspaces.Current(db, r, userId)
 		if err != nil {
-			http.Error(w, "Error decoding request: "+err.Error(), http.StatusBadRequest)
+			utils.WriteError(w, r, err)
 			return
 		}
-		_, err = url.ParseRequestURI(bookmark.Url)
+
+		bookmark, err := utils.DecodeRequestBody[bookmarkRequest](r)
 		if err != nil {
-			http.Error(w, "Invalid URL: "+err.Error(), http.StatusBadRequest)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		tx, err := db.Begin()
+		if errs := bookmark.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
 
+		tx, err := db.Begin()
 		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Couldn't start transaction: "+err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if err := checkNotTrashed(tx, workspaceId, bookmark.Url); err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		if err := tags.CreateTags(tx, bookmark.Tags, string(userId)); err != nil {
-			tx.Rollback()
-			http.Error(w, "Error creating tags: "+err.Error(), http.StatusInternalServerError)
+		if _, err := addRuleTags(tx, workspaceId, userId, bookmark); err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		bookmarksExecResult, err := utils.Exec(tx, utils.CREATE_BOOKMARK, userId, bookmark.Url, bookmark.Title, bookmark.Description, bookmark.Notes)
+		bookmarksExecResult, err := utils.Exec(
+			tx, utils.CREATE_BOOKMARK,
+			userId, workspaceId, bookmark.Url, bookmark.Title, bookmark.Description, bookmark.Notes,
+			bookmark.Status, bookmark.IsFavorite, bookmark.IsArchived, bookmark.Status,
+		)
 		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Error creating bookmark"+err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
 			return
 		}
 
 		bookmarkId, err := bookmarksExecResult.LastInsertId()
 		if err != nil {
-			tx.Rollback()
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		savedTags, err := tags.GetTags(tx, bookmark.Tags, string(userId))
-		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Error getting tags Ids: "+err.Error(), http.StatusInternalServerError)
+		if err := setBookmarkTags(tx, bookmarkId, bookmark.Tags, workspaceId, string(userId)); err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		err = tags.UpdateBookmarkTags(tx, bookmarkId, tags.TagIds(savedTags))
-		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Error updating boookmark_tags table"+err.Error(), http.StatusInternalServerError)
+		if err := recordRevision(tx, bookmarkId); err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
+
 		if err := tx.Commit(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
+		w.Header().Set("Location", fmt.Sprintf("/api/bookmarks/%d", bookmarkId))
+		writeBookmark(w, r, db, workspaceId, strconv.FormatInt(bookmarkId, 10), http.StatusCreated)
 	}
 }
 
+// UpdateBookmarkHandler fully replaces a bookmark: fields missing from the
+// request body are cleared and the tags are replaced by the given list, plus
+// the tags of the user's matching rules when the URL or title changed.
 func UpdateBookmarkHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		if _, err := strconv.Atoi(id); err != nil || id == "" {
-			http.Error(w, "Invalid bookmark ID", http.StatusBadRequest)
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		userId, httpStatus, err := utils.IsAuthenticated(r)
+		userId, err := utils.IsAuthenticated(r)
 		if err != nil {
-			http.Error(w, err.Error(), httpStatus)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		existingBookmark, httpStatus, err := utils.FindOne(findBookmark(db, id, string(userId)), bookmarkScanner)
+		workspaceId, err := workspaces.Current(db, r, userId)
 		if err != nil {
-			http.Error(w, err.Error(), httpStatus)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		newBookmark, err := utils.DecodeRequestBody[struct {
-			Bookmark
-			Tags []string
-		}](r)
+		bookmarkWorkspaceId, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_EDITOR)
 		if err != nil {
-			http.Error(w, "Error decoding request: "+err.Error(), http.StatusBadRequest)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		_, err = url.ParseRequestURI(newBookmark.Url)
-		if newBookmark.Url != "" && err != nil {
-			http.Error(w, "Invalid URL: "+err.Error(), http.StatusBadRequest)
+		existingBookmark, err := utils.FindOne(findBookmark(db, id, bookmarkWorkspaceId), bookmarkScanner)
+		if err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		tx, err := db.Begin()
+		if err := utils.CheckIfMatch(r, utils.VersionETag(existingBookmark.Version)); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		newBookmark, err := utils.DecodeRequestBody[bookmarkRequest](r)
 		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Couldn't start transaction: "+err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		if err := execUpdateBookmark(tx, string(userId), *existingBookmark, newBookmark.Bookmark); err != nil {
-			tx.Rollback()
-			http.Error(w, "Bookmark update failed: "+err.Error(), http.StatusInternalServerError)
+		if errs := newBookmark.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
 			return
 		}
 
-		if err := deleteBookmarkTagIds(tx, id); err != nil {
-			tx.Rollback()
-			http.Error(w, "Couldn't delete existing mappings in junction table: "+err.Error(), http.StatusInternalServerError)
+		newBookmark.Id = existingBookmark.Id
+		newBookmark.Version = existingBookmark.Version
+		applyRules := newBookmark.Url != existingBookmark.Url || newBookmark.Title != existingBookmark.Title
+		if err := saveBookmark(db, bookmarkWorkspaceId, string(userId), newBookmark, true, applyRules); err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		if err := tags.CreateTags(tx, newBookmark.Tags, string(userId)); err != nil {
-			tx.Rollback()
-			http.Error(w, "Error creating new tags: "+err.Error(), http.StatusInternalServerError)
+		writeBookmark(w, r, db, bookmarkWorkspaceId, id, http.StatusOK)
+	}
+}
+
+// PatchBookmarkHandler partially updates a bookmark using JSON Merge Patch
+// semantics: absent fields are kept and null fields are cleared. The user's
+// matching rules add their tags when the URL or title changed, unless the
+// patch sets the tags itself.
+func PatchBookmarkHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		savedTags, err := tags.GetTags(tx, newBookmark.Tags, string(userId))
+		userId, err := utils.IsAuthenticated(r)
 		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Error getting tags Ids: "+err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		err = tags.UpdateBookmarkTags(tx, int64(existingBookmark.Id), tags.TagIds(savedTags))
+		workspaceId, err := workspaces.Current(db, r, userId)
 		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Error updating boookmark_tags junction table"+err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		if err := tx.Commit(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		bookmarkWorkspaceId, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_EDITOR)
+		if err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
+		existingBookmark, err := getBookmark(db, bookmarkWorkspaceId, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := utils.CheckIfMatch(r, existingBookmark.ETag); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		patch, err := utils.DecodeRequestBody[map[string]json.RawMessage](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		newBookmark, tagsPatched, errs := applyMergePatch(*existingBookmark, *patch)
+		errs.Merge(validatePatch(newBookmark, *patch))
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		applyRules := !tagsPatched &&
+			(newBookmark.Url != existingBookmark.Url || newBookmark.Title != existingBookmark.Title)
+		if err := saveBookmark(db, bookmarkWorkspaceId, string(userId), newBookmark, tagsPatched, applyRules); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeBookmark(w, r, db, bookmarkWorkspaceId, id, http.StatusOK)
 	}
 }
 
 func DeleteBookmarkHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		if _, err := strconv.Atoi(id); err != nil || id == "" {
-			http.Error(w, "Invalid bookmark ID", http.StatusBadRequest)
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		userId, httpStatus, err := utils.IsAuthenticated(r)
+		userId, err := utils.IsAuthenticated(r)
 		if err != nil {
-			http.Error(w, err.Error(), httpStatus)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		_, httpStatus, err = utils.FindOne(findBookmark(db, id, string(userId)), bookmarkScanner)
+		workspaceId, err := workspaces.Current(db, r, userId)
 		if err != nil {
-			http.Error(w, err.Error(), httpStatus)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		if _, err := utils.Exec(db, utils.DELETE_BOOKMARK, id, userId); err != nil {
-			http.Error(w, "Error deleting bookmark: "+err.Error(), http.StatusInternalServerError)
+		bookmarkWorkspaceId, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_OWNER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		bookmark, err := utils.FindOne(findBookmark(db, id, bookmarkWorkspaceId), bookmarkScanner)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := utils.CheckIfMatch(r, utils.VersionETag(bookmark.Version)); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, utils.DELETE_BOOKMARK, id, bookmarkWorkspaceId, bookmark.Version)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if err := requireAffected(result); err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
@@ -278,54 +426,258 @@ func DeleteBookmarkHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func bookmarksListQuery(userID string, queryParams BookmarksQueryParams) string {
-	var search string
-	var tagsQuery string
+// saveBookmark writes every field of an existing bookmark of the workspace
+// and, when replaceTags is set, replaces its tags, all in a single transaction.
+// When applyRules is set, the tags of userId's matching rules are added in
+// that transaction too. New tags are recorded as created by userId.
+func saveBookmark(db *sql.DB, workspaceId, userId string, bookmark *bookmarkRequest, replaceTags, applyRules bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if applyRules {
+		ruleTagged, err := addRuleTags(tx, workspaceId, utils.UserId(userId), bookmark)
+		if err != nil {
+			return err
+		}
+		replaceTags = replaceTags || ruleTagged
+	}
+
+	if err := execUpdateBookmark(tx, workspaceId, bookmark.Bookmark); err != nil {
+		return err
+	}
+
+	if replaceTags {
+		if err := deleteBookmarkTagIds(tx, strconv.Itoa(bookmark.Id)); err != nil {
+			return err
+		}
 
-	if len(queryParams.tags) > 0 {
-		placeholders := []string{}
-		for _, _ = range queryParams.tags {
-			placeholders = append(placeholders, "?")
+		if err := setBookmarkTags(tx, int64(bookmark.Id), bookmark.Tags, workspaceId, userId); err != nil {
+			return err
 		}
-		tagsQuery = "t.name IN(" + strings.Join(placeholders, ", ") + ")"
-	} else {
-		tagsQuery = "1=1"
 	}
 
-	if queryParams.search == "" {
-		search = "1=1"
+	if err := recordRevision(tx, int64(bookmark.Id)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// requireAffected turns a version-guarded write that matched no rows into a
+// precondition failure: someone else changed the bookmark in the meantime.
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return utils.PreconditionFailed("The bookmark was modified concurrently, fetch it and retry")
+	}
+	return nil
+}
+
+// writeBookmark responds to a successful write with the stored bookmark,
+// or with an empty body when the client asked for "Prefer: return=minimal".
+func writeBookmark(w http.ResponseWriter, r *http.Request, db *sql.DB, workspaceId, id string, status int) {
+	if utils.PrefersMinimal(r) {
+		w.Header().Set("Preference-Applied", "return=minimal")
+		if status == http.StatusOK {
+			status = http.StatusNoContent
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	bookmark, err := getBookmark(db, workspaceId, id)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if err := expandTags(db, r, []*BookmarkWithTags{bookmark}); err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", bookmark.ETag)
+	utils.WriteJSON(w, status, bookmark)
+}
+
+// checkNotTrashed reports a conflict pointing at the trashed bookmark when the
+// URL belongs to a bookmark in the trash, so that clients can offer to restore it.
+func checkNotTrashed(execer utils.Execer, workspaceId, url string) error {
+	stmt, err := execer.Prepare(utils.FIND_TRASHED_BOOKMARK)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var trashedId int
+	err = stmt.QueryRow(workspaceId, url).Scan(&trashedId)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	conflict := utils.Conflict("A bookmark with this URL is in the trash")
+	conflict.Details = map[string]any{"fields": []string{"url"}, "trashed_id": trashedId}
+	return conflict
+}
+
+// setBookmarkTags creates any missing tags in the workspace and links them to
+// the bookmark. Aliases tag the bookmark with the tags they stand for.
+func setBookmarkTags(tx utils.Execer, bookmarkId int64, tagNames []string, workspaceId, userId string) error {
+	if len(tagNames) == 0 {
+		return nil
+	}
+
+	tagNames, err := tags.ResolveAliases(tx, tagNames, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	if err := tags.CreateTags(tx, tagNames, workspaceId, userId); err != nil {
+		return err
+	}
+
+	savedTags, err := tags.GetTags(tx, tagNames, workspaceId)
+	if err != nil {
+		return err
+	}
+
+	return tags.UpdateBookmarkTags(tx, bookmarkId, tags.TagIds(savedTags))
+}
+
+// liveTagsJoin attaches the names of a bookmark's tags, skipping tags that are
+// in the trash. It expects bookmarks to be aliased as b.
+const liveTagsJoin = `LEFT JOIN (bookmark_tags b_t JOIN tags t ON b_t.tag_id = t.id AND t.deleted_at IS NULL)
+		ON b.id = b_t.bookmark_id`
+
+// bookmarksFilter builds the WHERE clause matching a workspace's bookmarks
+// against the list filters, together with its arguments. It expects bookmarks
+// to be aliased as b.
+//
+// When filtering by collection, every bookmark in it matches regardless of
+// its workspace since collections can be shared. Callers check the user may
+// see the collection.
+func bookmarksFilter(workspaceId string, queryParams BookmarksQueryParams) (string, []any) {
+	conditions := []string{"b.deleted_at IS NULL"}
+	args := []any{}
+
+	if queryParams.collection != "" {
+		conditions = append(conditions, "b.id IN (SELECT bookmark_id FROM collection_bookmarks WHERE collection_id = ?)")
+		args = append(args, queryParams.collection)
 	} else {
-		search = "title LIKE ? OR description LIKE ? OR notes LIKE ?"
+		conditions = append(conditions, "b.workspace_id = ?")
+		args = append(args, workspaceId)
+	}
+
+	if queryParams.search != "" {
+		pattern := "%" + queryParams.search + "%"
+		conditions = append(conditions, `(b.title LIKE ? OR b.description LIKE ? OR b.notes LIKE ?
+			OR b.id IN (SELECT bookmark_id FROM annotations WHERE quote LIKE ? OR comment LIKE ?))`)
+		args = append(args, pattern, pattern, pattern, pattern, pattern)
 	}
 
+	if queryParams.status != "" {
+		conditions = append(conditions, "b.status = ?")
+		args = append(args, queryParams.status)
+	}
+
+	if queryParams.favorite != nil {
+		conditions = append(conditions, "b.is_favorite = ?")
+		args = append(args, *queryParams.favorite)
+	}
+
+	switch queryParams.archived {
+	case ARCHIVED_ONLY:
+		conditions = append(conditions, "b.is_archived")
+	case ARCHIVED_INCLUDE:
+	default:
+		conditions = append(conditions, "NOT b.is_archived")
+	}
+
+	if queryParams.tag != "" && queryParams.descendants {
+		conditions = append(conditions, `b.id IN (
+			SELECT b_t.bookmark_id
+			FROM bookmark_tags b_t
+			JOIN tags t ON b_t.tag_id = t.id
+			JOIN tags p ON p.id = ? AND t.workspace_id = p.workspace_id
+			WHERE t.deleted_at IS NULL AND `+tags.SubtreeCondition("p.name")+`)`)
+		args = append(args, queryParams.tag)
+	} else if queryParams.tag != "" {
+		conditions = append(conditions, "b.id IN (SELECT bookmark_id FROM bookmark_tags WHERE tag_id = ?)")
+		args = append(args, queryParams.tag)
+	}
+
+	if len(queryParams.tags) > 0 && queryParams.descendants {
+		matches := make([]string, len(queryParams.tags))
+		for i, tag := range queryParams.tags {
+			matches[i] = tags.SubtreeCondition("?")
+			args = append(args, tag, tag, tag)
+		}
+		conditions = append(conditions, `b.id IN (
+			SELECT b_t.bookmark_id
+			FROM bookmark_tags b_t
+			JOIN tags t ON b_t.tag_id = t.id
+			WHERE t.deleted_at IS NULL AND (`+strings.Join(matches, " OR ")+`))`)
+	} else if len(queryParams.tags) > 0 {
+		conditions = append(conditions, `b.id IN (
+			SELECT b_t.bookmark_id
+			FROM bookmark_tags b_t
+			JOIN tags t ON b_t.tag_id = t.id
+			WHERE t.deleted_at IS NULL AND t.name IN (`+utils.Placeholders(len(queryParams.tags))+`))`)
+		for _, tag := range queryParams.tags {
+			args = append(args, tag)
+		}
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// bookmarksListQuery pages over bookmarks first and joins their tags after,
+// so that every bookmark on the page comes with all of its tags.
+func bookmarksListQuery(workspaceId string, queryParams BookmarksQueryParams) (string, []any) {
+	filter, args := bookmarksFilter(workspaceId, queryParams)
+	column := "b." + queryParams.sort
+	if queryParams.sort == SORT_POSITION {
+		column = "(SELECT position FROM collection_bookmarks WHERE bookmark_id = b.id)"
+	}
+	order := fmt.Sprintf("%s %s, b.id %s", column, queryParams.order, queryParams.order)
+
 	query := fmt.Sprintf(`
-		SELECT DISTINCT b.id, b.url, b.title, b.description, b.notes, b.created_at, b.updated_at, t.name
+		SELECT %s, t.name
 		FROM bookmarks b
-		LEFT JOIN bookmark_tags b_t
-		ON b.id = b_t.bookmark_id
-		LEFT JOIN tags t
-		ON b_t.tag_id = t.id
-		WHERE b.user_id = %s
-		  AND %s
-		  AND %s
-		ORDER BY b.%s %s
-		LIMIT ? OFFSET ?;`,
-		userID, search, tagsQuery, queryParams.sort, queryParams.order)
-
-	return query
+		%s
+		WHERE b.id IN (
+			SELECT b.id
+			FROM bookmarks b
+			WHERE %s
+			ORDER BY %s
+			LIMIT ? OFFSET ?
+		)
+		ORDER BY %s, t.name;`,
+		bookmarkColumns, liveTagsJoin, filter, order, order)
+
+	args = append(args, queryParams.limit, queryParams.limit*(queryParams.page-1))
+	return query, args
 }
 
-func bookmarkByIdQueryRunner(db *sql.DB, userId, bookmarkId string) func() (*sql.Stmt, *sql.Rows, error) {
+func bookmarkByIdQueryRunner(db *sql.DB, workspaceId, bookmarkId string) func() (*sql.Stmt, *sql.Rows, error) {
 	return func() (*sql.Stmt, *sql.Rows, error) {
 		query := `
-			SELECT b.id, b.url, b.title, b.description, b.notes, b.created_at, b.updated_at, t.name
+			SELECT ` + bookmarkColumns + `, t.name
 			FROM bookmarks b
-			LEFT JOIN bookmark_tags b_t
-			ON b.id = b_t.bookmark_id
-			LEFT JOIN tags t
-			ON b_t.tag_id = t.id
-			WHERE b.user_id = ?
+			` + liveTagsJoin + `
+			WHERE b.workspace_id = ?
 			  AND b.id = ?
+			  AND b.deleted_at IS NULL
+			ORDER BY t.name
 		`
 
 		stmt, err := db.Prepare(query)
@@ -333,7 +685,7 @@ func bookmarkByIdQueryRunner(db *sql.DB, userId, bookmarkId string) func() (*sql
 			return nil, nil, err
 		}
 
-		rows, err := db.Query(query, userId, bookmarkId)
+		rows, err := stmt.Query(workspaceId, bookmarkId)
 		if err != nil {
 			return stmt, nil, err
 		}
@@ -348,16 +700,7 @@ func bookmarksScanner(rows *sql.Rows) ([]BookmarkWithTag, error) {
 	for rows.Next() {
 		var bookmark BookmarkWithTag
 		var tag sql.NullString
-		err := rows.Scan(
-			&bookmark.Id,
-			&bookmark.Url,
-			&bookmark.Title,
-			&bookmark.Description,
-			&bookmark.Notes,
-			&bookmark.CreatedAt,
-			&bookmark.UpdatedAt,
-			&tag,
-		)
+		err := rows.Scan(append(bookmark.scanFields(), &tag)...)
 
 		if err != nil {
 			return nil, err
@@ -374,27 +717,34 @@ func bookmarksScanner(rows *sql.Rows) ([]BookmarkWithTag, error) {
 }
 
 func normalizeBookmarks(bookmarks []BookmarkWithTag) []BookmarkWithTags {
-	var result []BookmarkWithTags
+	result := []BookmarkWithTags{}
+	// indexes maps bookmark ids to their position in result, which exports
+	// of whole workspaces can't afford to search.
+	indexes := map[int]int{}
 
 	for _, bookmark := range bookmarks {
-		indexOfExistingBookmark := slices.IndexFunc(result, func(e BookmarkWithTags) bool {
-			return e.Id == bookmark.Id
-		})
+		indexOfExistingBookmark, ok := indexes[bookmark.Id]
+		if !ok {
+			indexOfExistingBookmark = -1
+			indexes[bookmark.Id] = len(result)
+		}
 
 		if indexOfExistingBookmark < 0 {
 			if bookmark.Tag == "" {
 				result = append(result, BookmarkWithTags{
 					Bookmark: bookmark.Bookmark,
-					Tags:     nil,
+					Tags:     []string{},
+					ETag:     utils.VersionETag(bookmark.Version),
 				})
 			} else {
 				result = append(result, BookmarkWithTags{
 					Bookmark: bookmark.Bookmark,
 					Tags:     []string{bookmark.Tag},
+					ETag:     utils.VersionETag(bookmark.Version),
 				})
 			}
 
-		} else {
+		} else if bookmark.Tag != "" {
 			result[indexOfExistingBookmark].Tags = append(result[indexOfExistingBookmark].Tags, bookmark.Tag)
 		}
 	}
@@ -403,23 +753,17 @@ func normalizeBookmarks(bookmarks []BookmarkWithTag) []BookmarkWithTags {
 }
 
 func bookmarksListQueryRunner(
-	db *sql.DB, userId string,
+	db *sql.DB, workspaceId string,
 	queryParams BookmarksQueryParams,
 ) func() (*sql.Stmt, *sql.Rows, error) {
 	return func() (*sql.Stmt, *sql.Rows, error) {
-		search := queryParams.search
-		query := bookmarksListQuery(userId, queryParams)
-
-		args := []any{}
-		if search != "" {
-			args = append(args, "%"+search+"%", "%"+search+"%", "%"+search+"%")
-		}
-		if len(queryParams.tags) > 0 {
-			for _, tag := range queryParams.tags {
-				args = append(args, tag)
-			}
+		tagNames, err := tags.ResolveAliases(db, queryParams.tags, workspaceId)
+		if err != nil {
+			return nil, nil, err
 		}
-		args = append(args, queryParams.limit, queryParams.limit*(queryParams.page-1))
+		queryParams.tags = tagNames
+
+		query, args := bookmarksListQuery(workspaceId, queryParams)
 
 		stmt, err := db.Prepare(query)
 		if err != nil {
@@ -444,21 +788,35 @@ func getQueryParams(r *http.Request) BookmarksQueryParams {
 		order: "desc",
 		tags:  []string{},
 	}
-	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil {
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && page > 0 {
 		defaultParams.page = page
 	}
 
-	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
-		defaultParams.limit = limit
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		defaultParams.limit = min(limit, MAX_PAGE_LIMIT)
+	}
+
+	if tagNames := r.URL.Query().Get("tags"); len(tagNames) > 0 {
+		defaultParams.tags = tags.NormalizeNames(strings.Split(tagNames, ","))
 	}
 
-	if tags := r.URL.Query().Get("tags"); len(tags) > 0 {
-		defaultParams.tags = strings.Split(tags, ",")
+	if descendants, err := strconv.ParseBool(r.URL.Query().Get("descendants")); err == nil {
+		defaultParams.descendants = descendants
 	}
 
 	defaultParams.search = r.URL.Query().Get("search")
 
-	if sort := r.URL.Query().Get("sort"); sort == "updated_at" || sort == "title" || sort == "url" {
+	if status := r.URL.Query().Get("status"); slices.Contains(bookmarkStatuses, status) {
+		defaultParams.status = status
+	}
+
+	if favorite, err := strconv.ParseBool(r.URL.Query().Get("favorite")); err == nil {
+		defaultParams.favorite = &favorite
+	}
+
+	defaultParams.archived = parseArchived(r.URL.Query().Get("archived"))
+
+	if sort := r.URL.Query().Get("sort"); slices.Contains(sortableColumns, sort) {
 		defaultParams.sort = sort
 	}
 
@@ -469,78 +827,53 @@ func getQueryParams(r *http.Request) BookmarksQueryParams {
 	return defaultParams
 }
 
-func execUpdateBookmark(
-	execer utils.Execer,
-	userId string,
-	existingBookmark Bookmark,
-	newBookmark Bookmark,
-) error {
-	var url, title, description, notes *string
-
-	if newBookmark.Url == "" {
-		url = nil
-	} else {
-		url = &existingBookmark.Url
-	}
-
-	if newBookmark.Title == "" {
-		title = nil
-	} else {
-		title = &existingBookmark.Title
+// execUpdateBookmark overwrites the bookmark as long as it's still at the
+// version that was read, bumping that version.
+func execUpdateBookmark(execer utils.Execer, workspaceId string, bookmark Bookmark) error {
+	result, err := utils.Exec(
+		execer, utils.UPDATE_BOOKMARK,
+		bookmark.Url, bookmark.Title, bookmark.Description, bookmark.Notes,
+		bookmark.Status, bookmark.IsFavorite, bookmark.IsArchived, bookmark.Status,
+		bookmark.Id, workspaceId, bookmark.Version,
+	)
+	if err != nil {
+		return err
 	}
+	return requireAffected(result)
+}
 
-	if newBookmark.Description == "" {
-		description = nil
-	} else {
-		description = &existingBookmark.Description
+// getBookmark loads a single bookmark together with its tags.
+func getBookmark(db *sql.DB, workspaceId, id string) (*BookmarkWithTags, error) {
+	bookmarks, err := utils.FindMany(bookmarkByIdQueryRunner(db, workspaceId, id), bookmarksScanner)
+	if err != nil {
+		return nil, err
 	}
-
-	if newBookmark.Notes == "" {
-		notes = nil
-	} else {
-		notes = &existingBookmark.Notes
+	if len(bookmarks) == 0 {
+		return nil, utils.NotFound("Bookmark not found")
 	}
 
-	query := fmt.Sprintf(`
-			UPDATE bookmarks
-			SET
-				url = COALESCE(?, '%v'),
-				title = COALESCE(?, '%s'),
-				description = COALESCE(?, '%s'),
-				notes = COALESCE(?, '%s')
-			WHERE
-				id = %d
-			AND user_id = %s;
-		`, *url, *title, *description, *notes, existingBookmark.Id, userId)
-
-	_, err := utils.Exec(execer, query, url, title, description, notes)
-	return err
+	return &normalizeBookmarks(bookmarks)[0], nil
 }
 
-func findBookmark(db *sql.DB, id, userId string) func() (*sql.Row, error) {
+func findBookmark(execer utils.Execer, id, workspaceId string) func() (*sql.Row, error) {
 	return func() (*sql.Row, error) {
-		stmt, err := db.Prepare(utils.GET_BOOKMARK)
+		stmt, err := execer.Prepare(`
+			SELECT ` + bookmarkColumns + `
+			FROM bookmarks b
+			WHERE b.id = ? AND b.workspace_id = ? AND b.deleted_at IS NULL`)
 
 		if err != nil {
 			return nil, err
 		}
 
-		return stmt.QueryRow(id, userId), nil
+		return stmt.QueryRow(id, workspaceId), nil
 	}
 }
 
 func bookmarkScanner(row *sql.Row) (*Bookmark, error) {
 	bookmark := new(Bookmark)
 
-	err := row.Scan(
-		&bookmark.Id,
-		&bookmark.Url,
-		&bookmark.Title,
-		&bookmark.Description,
-		&bookmark.Notes,
-		&bookmark.CreatedAt,
-		&bookmark.UpdatedAt,
-	)
+	err := row.Scan(bookmark.scanFields()...)
 
 	return bookmark, err
 }
@@ -0,0 +1,341 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/collections"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/tags"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+type bulkAction string
+
+const (
+	BULK_DELETE      bulkAction = "delete"
+	BULK_ADD_TAGS    bulkAction = "add_tags"
+	BULK_REMOVE_TAGS bulkAction = "remove_tags"
+	BULK_SET_TAGS    bulkAction = "set_tags"
+	BULK_EDIT_TAGS   bulkAction = "edit_tags"
+	BULK_ARCHIVE     bulkAction = "archive"
+	BULK_UNARCHIVE   bulkAction = "unarchive"
+	BULK_MOVE        bulkAction = "move_to_collection"
+)
+
+const MAX_BULK_IDS = 1000
+
+var bulkActions = []bulkAction{
+	BULK_DELETE, BULK_ADD_TAGS, BULK_REMOVE_TAGS, BULK_SET_TAGS, BULK_EDIT_TAGS, BULK_ARCHIVE, BULK_UNARCHIVE, BULK_MOVE,
+}
+
+type bulkFilter struct {
+	Tags        []string `json:"tags"`
+	Descendants bool     `json:"descendants"`
+	Search      string   `json:"search"`
+	Status      string   `json:"status"`
+	Favorite    *bool    `json:"favorite"`
+	Archived    string   `json:"archived"`
+}
+
+type bulkRequest struct {
+	Action bulkAction  `json:"action"`
+	Ids    []int       `json:"ids"`
+	Filter *bulkFilter `json:"filter"`
+	Tags   []string    `json:"tags"`
+	// Add and Remove are the tags the edit_tags action adds and removes.
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+	// CollectionId is the destination of the move_to_collection action.
+	CollectionId *int `json:"collection_id"`
+	DryRun       bool `json:"dry_run"`
+}
+
+type bulkItemResult struct {
+	Id     int    `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Action    bulkAction `json:"action"`
+	DryRun    bool       `json:"dry_run"`
+	Matched   int        `json:"matched"`
+	Succeeded int        `json:"succeeded"`
+	// Unchanged counts the bookmarks a tag action left as they were.
+	Unchanged int              `json:"unchanged"`
+	Failed    int              `json:"failed"`
+	Results   []bulkItemResult `json:"results"`
+}
+
+// BulkBookmarksHandler applies one action to many bookmarks, picked either by
+// id or by the same filters the list endpoint accepts. All changes happen in a
+// single transaction, which is rolled back instead of committed on dry runs.
+func BulkBookmarksHandler(db *sql.DB) http.HandlerFunc {
+	return bulkHandler(db, utils.DecodeRequestBody[bulkRequest])
+}
+
+// bulkHandler runs the bulk action decode reads from the request.
+func bulkHandler(db *sql.DB, decode func(*http.Request) (*bulkRequest, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := decode(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if errs := request.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if request.Action == BULK_MOVE {
+			if _, err := collections.Find(tx, strconv.Itoa(*request.CollectionId), workspaceId, string(userId), collections.ROLE_EDITOR); err != nil {
+				var apiErr *utils.APIError
+				if errors.As(err, &apiErr) && apiErr.Code == utils.NOT_FOUND {
+					err = utils.ValidationFailed(utils.FieldErrors{"collection_id": {"Collection not found"}})
+				}
+				utils.WriteError(w, r, err)
+				return
+			}
+		}
+
+		// Resolving aliases up front lets tag limits and removals count each
+		// tag once.
+		request.Tags, err = tags.ResolveAliases(tx, request.Tags, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if request.Add, err = tags.ResolveAliases(tx, request.Add, workspaceId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if request.Remove, err = tags.ResolveAliases(tx, request.Remove, workspaceId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		// An alias and its tag can only clash once aliases are resolved.
+		if errs := checkNotBothAddedAndRemoved(request.Add, request.Remove); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		ids := request.Ids
+		if request.Filter != nil {
+			ids, err = filterBookmarkIds(tx, workspaceId, *request.Filter)
+			if err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+		}
+
+		response := bulkResponse{
+			Action:  request.Action,
+			DryRun:  request.DryRun,
+			Matched: len(ids),
+			Results: make([]bulkItemResult, 0, len(ids)),
+		}
+
+		for _, id := range ids {
+			changed, err := applyBulkAction(tx, workspaceId, string(userId), request, id)
+
+			var apiErr *utils.APIError
+			switch {
+			case err == nil && changed:
+				response.Succeeded++
+				response.Results = append(response.Results, bulkItemResult{Id: id, Status: "ok"})
+			case err == nil:
+				response.Unchanged++
+				response.Results = append(response.Results, bulkItemResult{Id: id, Status: "unchanged"})
+			case errors.As(err, &apiErr):
+				response.Failed++
+				response.Results = append(response.Results, bulkItemResult{Id: id, Status: "failed", Error: apiErr.Message})
+			default:
+				utils.WriteError(w, r, err)
+				return
+			}
+		}
+
+		if !request.DryRun {
+			if err := tx.Commit(); err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+		}
+
+		utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+func (b *bulkRequest) validate() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	if !slices.Contains(bulkActions, b.Action) {
+		supported := make([]string, len(bulkActions))
+		for i, action := range bulkActions {
+			supported[i] = string(action)
+		}
+		errs.Add("action", "Action should be one of: "+strings.Join(supported, ", "))
+	}
+
+	if (len(b.Ids) == 0) == (b.Filter == nil) {
+		errs.Add("ids", "Provide either a list of ids or a filter")
+	}
+	if len(b.Ids) > MAX_BULK_IDS {
+		errs.Add("ids", fmt.Sprintf("At most %d ids are allowed per request", MAX_BULK_IDS))
+	}
+	b.Ids = slices.Compact(slices.Sorted(slices.Values(b.Ids)))
+
+	if b.Filter != nil {
+		b.Filter.Tags = tags.NormalizeNames(b.Filter.Tags)
+		if b.Filter.Status != "" {
+			if err := validateStatus(b.Filter.Status); err != nil {
+				errs.Add("filter.status", err.Error())
+			}
+		}
+	}
+
+	switch b.Action {
+	case BULK_ADD_TAGS, BULK_REMOVE_TAGS, BULK_SET_TAGS:
+		b.Tags = tags.NormalizeNames(b.Tags)
+		if len(b.Tags) == 0 && b.Action != BULK_SET_TAGS {
+			errs.Add("tags", "At least one tag is required")
+		}
+		errs.Merge(tags.ValidateNames("tags", b.Tags))
+	case BULK_EDIT_TAGS:
+		b.Add = tags.NormalizeNames(b.Add)
+		b.Remove = tags.NormalizeNames(b.Remove)
+		if len(b.Add) == 0 && len(b.Remove) == 0 {
+			errs.Add("add", "At least one tag to add or remove is required")
+		}
+		errs.Merge(tags.ValidateNames("add", b.Add))
+		errs.Merge(tags.ValidateNames("remove", b.Remove))
+		errs.Merge(checkNotBothAddedAndRemoved(b.Add, b.Remove))
+	case BULK_MOVE:
+		if b.CollectionId == nil {
+			errs.Add("collection_id", "Collection id is required")
+		}
+	}
+
+	return errs
+}
+
+func filterBookmarkIds(tx *sql.Tx, workspaceId string, filter bulkFilter) ([]int, error) {
+	tagNames, err := tags.ResolveAliases(tx, filter.Tags, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := bookmarksFilter(workspaceId, BookmarksQueryParams{
+		tags:        tagNames,
+		descendants: filter.Descendants,
+		search:      filter.Search,
+		status:      filter.Status,
+		favorite:    filter.Favorite,
+		archived:    parseArchived(filter.Archived),
+	})
+
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := tx.Prepare("SELECT b.id FROM bookmarks b WHERE " + where + " ORDER BY b.id")
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(args...)
+			return stmt, rows, err
+		},
+		idsScanner,
+	)
+}
+
+func idsScanner(rows *sql.Rows) ([]int, error) {
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// applyBulkAction runs the requested action against a single bookmark of the
+// workspace and tells whether that changed it, which only tag additions and
+// removals may not. Problems specific to that bookmark are returned as
+// APIErrors and reported per item.
+func applyBulkAction(tx *sql.Tx, workspaceId, userId string, request *bulkRequest, id int) (bool, error) {
+	bookmark, err := utils.FindOne(findBookmark(tx, strconv.Itoa(id), workspaceId), bookmarkScanner)
+	if err != nil {
+		var apiErr *utils.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == utils.NOT_FOUND {
+			return false, utils.NotFound("Bookmark not found")
+		}
+		return false, err
+	}
+	bookmarkId := int64(bookmark.Id)
+
+	switch request.Action {
+	case BULK_DELETE:
+		result, err := utils.Exec(tx, utils.DELETE_BOOKMARK, bookmark.Id, workspaceId, bookmark.Version)
+		if err != nil {
+			return false, err
+		}
+		return true, requireAffected(result)
+	case BULK_ADD_TAGS:
+		return editBookmarkTags(tx, workspaceId, userId, bookmark.Id, request.Tags, nil)
+	case BULK_REMOVE_TAGS:
+		return editBookmarkTags(tx, workspaceId, userId, bookmark.Id, nil, request.Tags)
+	case BULK_EDIT_TAGS:
+		return editBookmarkTags(tx, workspaceId, userId, bookmark.Id, request.Add, request.Remove)
+	case BULK_SET_TAGS:
+		if err = deleteBookmarkTagIds(tx, strconv.Itoa(bookmark.Id)); err == nil {
+			err = setBookmarkTags(tx, bookmarkId, request.Tags, workspaceId, userId)
+		}
+	case BULK_ARCHIVE, BULK_UNARCHIVE:
+		var result sql.Result
+		result, err = utils.Exec(tx, SET_BOOKMARK_ARCHIVED, request.Action == BULK_ARCHIVE, bookmark.Id, workspaceId, bookmark.Version)
+		if err != nil {
+			return false, err
+		}
+		// The query already bumped the version.
+		return true, requireAffected(result)
+	case BULK_MOVE:
+		// Filing bumps the version itself, and collections aren't part of revisions.
+		return true, collections.FileBookmarks(tx, workspaceId, *request.CollectionId, []int{bookmark.Id}, nil)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := utils.Exec(tx, utils.BUMP_BOOKMARK_VERSION, bookmark.Id, workspaceId); err != nil {
+		return false, err
+	}
+
+	return true, recordRevision(tx, bookmarkId)
+}
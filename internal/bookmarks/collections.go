@@ -0,0 +1,112 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/collections"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+// SORT_POSITION orders bookmarks the way they were arranged in their
+// collection. It's only accepted when listing a collection.
+const SORT_POSITION = "position"
+
+// GetCollectionBookmarksHandler lists the bookmarks filed directly in a
+// collection, whoever they belong to when it's shared. It takes the same
+// filters as the bookmarks list and defaults to the collection's manual order.
+func GetCollectionBookmarksHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := collections.Find(db, id, workspaceId, string(userId), collections.ROLE_VIEWER); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		queryParams := getQueryParams(r)
+		queryParams.collection = id
+		if sort := r.URL.Query().Get("sort"); sort == "" || sort == SORT_POSITION {
+			queryParams.sort = SORT_POSITION
+			if r.URL.Query().Get("order") != "desc" {
+				queryParams.order = "asc"
+			}
+		}
+
+		bookmarks, err := utils.FindMany(
+			bookmarksListQueryRunner(db, workspaceId, queryParams),
+			bookmarksScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeBookmarks(w, r, db, normalizeBookmarks(bookmarks))
+	}
+}
+
+// bookmarkWorkspace makes sure the user acting on a workspace may act on a
+// bookmark with at least the required role, either because it's in that
+// workspace or because it's filed in a collection shared with them, and
+// returns the id of the bookmark's workspace. Queries on the bookmark are
+// scoped by that workspace rather than by the one the user acts on.
+func bookmarkWorkspace(execer utils.Execer, id, workspaceId string, userId utils.UserId, required string) (string, error) {
+	stmt, err := execer.Prepare(`
+		SELECT b.workspace_id, c_b.collection_id
+		FROM bookmarks b
+		LEFT JOIN collection_bookmarks c_b ON c_b.bookmark_id = b.id
+		WHERE b.id = ? AND b.deleted_at IS NULL`)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	var bookmarkWorkspaceId string
+	var collectionId sql.NullString
+	err = stmt.QueryRow(id).Scan(&bookmarkWorkspaceId, &collectionId)
+	if err == sql.ErrNoRows {
+		return "", utils.NotFound("Bookmark not found")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if bookmarkWorkspaceId == workspaceId {
+		return bookmarkWorkspaceId, nil
+	}
+	if !collectionId.Valid {
+		return "", utils.NotFound("Bookmark not found")
+	}
+
+	role, err := collections.RoleOf(execer, collectionId.String, workspaceId, string(userId))
+	if err != nil {
+		return "", err
+	}
+	if role == "" {
+		return "", utils.NotFound("Bookmark not found")
+	}
+	if !collections.Allows(role, required) {
+		return "", utils.Forbidden(fmt.Sprintf("This requires the %s role on the bookmark's collection", required))
+	}
+
+	return bookmarkWorkspaceId, nil
+}
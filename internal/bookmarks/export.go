@@ -0,0 +1,106 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/tags"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+// ExportedBookmark is a bookmark as exported, with everything annotated on it.
+type ExportedBookmark struct {
+	BookmarkWithTags
+	Annotations []Annotation `json:"annotations"`
+}
+
+type exportResponse struct {
+	ExportedAt time.Time          `json:"exported_at"`
+	Bookmarks  []ExportedBookmark `json:"bookmarks"`
+}
+
+// ExportBookmarksHandler downloads the workspace's bookmarks outside the
+// trash as one JSON document, oldest first, each with its tags and
+// annotations. It takes the filters of the bookmark list, except that
+// archived bookmarks are included unless ?archived says otherwise.
+func ExportBookmarksHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		queryParams := getQueryParams(r)
+		if r.URL.Query().Get("archived") == "" {
+			queryParams.archived = ARCHIVED_INCLUDE
+		}
+
+		// Bookmarks and annotations are read in one transaction so that
+		// every annotation has its bookmark.
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		queryParams.tags, err = tags.ResolveAliases(tx, queryParams.tags, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		filter, args := bookmarksFilter(workspaceId, queryParams)
+
+		rows, err := utils.FindMany(
+			func() (*sql.Stmt, *sql.Rows, error) {
+				stmt, err := tx.Prepare(`
+					SELECT ` + bookmarkColumns + `, t.name
+					FROM bookmarks b
+					` + liveTagsJoin + `
+					WHERE ` + filter + `
+					ORDER BY b.created_at, b.id, t.name`)
+				if err != nil {
+					return nil, nil, err
+				}
+				rows, err := stmt.Query(args...)
+				return stmt, rows, err
+			},
+			bookmarksScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		annotations, err := getAnnotations(tx, `a.bookmark_id IN (SELECT b.id FROM bookmarks b WHERE `+filter+`)`, args...)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		byBookmark := map[int][]Annotation{}
+		for _, annotation := range annotations {
+			byBookmark[annotation.BookmarkId] = append(byBookmark[annotation.BookmarkId], annotation)
+		}
+
+		response := exportResponse{ExportedAt: time.Now().UTC(), Bookmarks: []ExportedBookmark{}}
+		for _, bookmark := range normalizeBookmarks(rows) {
+			exported := ExportedBookmark{BookmarkWithTags: bookmark, Annotations: byBookmark[bookmark.Id]}
+			if exported.Annotations == nil {
+				exported.Annotations = []Annotation{}
+			}
+			response.Bookmarks = append(response.Bookmarks, exported)
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="bookmarks.json"`)
+		utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
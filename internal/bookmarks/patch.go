@@ -0,0 +1,92 @@
+package bookmarks
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+var patchableFields = []string{"url", "title", "description", "notes", "status", "is_favorite", "is_archived", "tags"}
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7386) document to an existing
+// bookmark. Absent members keep their current value and null members clear it.
+// The returned flag tells whether the patch touches the bookmark's tags.
+func applyMergePatch(existing BookmarkWithTags, patch map[string]json.RawMessage) (*bookmarkRequest, bool, utils.FieldErrors) {
+	errs := utils.FieldErrors{}
+	result := &bookmarkRequest{Bookmark: existing.Bookmark, Tags: existing.Tags}
+
+	for field := range patch {
+		if !slices.Contains(patchableFields, field) {
+			errs.Add(field, "Unknown or read-only field")
+		}
+	}
+
+	stringFields := map[string]*string{
+		"url":         &result.Url,
+		"title":       &result.Title,
+		"description": &result.Description,
+		"notes":       &result.Notes,
+		"status":      &result.Status,
+	}
+	for field, target := range stringFields {
+		value, ok := patch[field]
+		if !ok {
+			continue
+		}
+		if isJSONNull(value) {
+			*target = ""
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			errs.Add(field, "Should be a string or null")
+		}
+	}
+
+	boolFields := map[string]*bool{
+		"is_favorite": &result.IsFavorite,
+		"is_archived": &result.IsArchived,
+	}
+	for field, target := range boolFields {
+		value, ok := patch[field]
+		if !ok {
+			continue
+		}
+		if isJSONNull(value) {
+			*target = false
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			errs.Add(field, "Should be a boolean or null")
+		}
+	}
+
+	value, tagsPatched := patch["tags"]
+	if tagsPatched {
+		result.Tags = nil
+		if !isJSONNull(value) {
+			if err := json.Unmarshal(value, &result.Tags); err != nil {
+				errs.Add("tags", "Should be an array of strings or null")
+			}
+		}
+	}
+
+	return result, tagsPatched, errs
+}
+
+// validatePatch validates a patched bookmark, reporting only the fields the
+// patch sets. Values the patch leaves alone are kept even when they wouldn't
+// pass validation anymore, such as tag lists grown past the limit by rules.
+func validatePatch(bookmark *bookmarkRequest, patch map[string]json.RawMessage) utils.FieldErrors {
+	errs := bookmark.validate()
+	maps.DeleteFunc(errs, func(field string, _ []string) bool {
+		_, patched := patch[field]
+		return !patched
+	})
+	return errs
+}
+
+func isJSONNull(value json.RawMessage) bool {
+	return string(value) == "null"
+}
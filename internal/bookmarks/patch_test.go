@@ -0,0 +1,54 @@
+package bookmarks
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func manyTags(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("tag-%d", i)
+	}
+	return names
+}
+
+func TestValidatePatch(t *testing.T) {
+	// The stored tags went past the limit, as rules and merges can make them.
+	existing := BookmarkWithTags{
+		Bookmark: Bookmark{Url: "https://example.com", Status: STATUS_UNREAD},
+		Tags:     manyTags(25),
+	}
+	tooMany, _ := json.Marshal(manyTags(21))
+
+	tests := []struct {
+		name      string
+		patch     string
+		wantField string
+	}{
+		{"unpatched stored tags are kept", `{"title": "New title"}`, ""},
+		{"patched url is validated", `{"url": "ftp://example.com"}`, "url"},
+		{"patched tags are validated", `{"tags": ` + string(tooMany) + `}`, "tags"},
+		{"patched tags within the limit pass", `{"tags": ["go"]}`, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			patch := map[string]json.RawMessage{}
+			if err := json.Unmarshal([]byte(test.patch), &patch); err != nil {
+				t.Fatal(err)
+			}
+
+			bookmark, _, errs := applyMergePatch(existing, patch)
+			errs.Merge(validatePatch(bookmark, patch))
+
+			if test.wantField == "" && errs.HasErrors() {
+				t.Errorf("unexpected errors: %v", errs)
+			}
+			if _, ok := errs[test.wantField]; test.wantField != "" && !ok {
+				t.Errorf("expected an error on %s, got %v", test.wantField, errs)
+			}
+		})
+	}
+}
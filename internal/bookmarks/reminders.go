@@ -0,0 +1,102 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/collections"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/reminders"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+// GetBookmarkRemindersHandler lists the reminders the user set on a bookmark.
+func GetBookmarkRemindersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_VIEWER); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		bookmarkReminders, err := reminders.Get(db, userId, `AND r.bookmark_id = ?`, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, bookmarkReminders)
+	}
+}
+
+// CreateReminderHandler sets a reminder on a bookmark. Reminders are personal,
+// anyone who can see the bookmark can set their own.
+func CreateReminderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[reminders.ReminderRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if errs := request.Validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if _, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_VIEWER); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		reminderId, err := reminders.Create(db, id, userId, request)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		reminder, err := reminders.Find(db, strconv.FormatInt(reminderId, 10), userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusCreated, reminder)
+	}
+}
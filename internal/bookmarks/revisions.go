@@ -0,0 +1,290 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/collections"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const (
+	// RECORD_REVISION snapshots the bookmark as it is now, unless nothing
+	// changed since the latest snapshot.
+	RECORD_REVISION = `
+		WITH current AS (
+			SELECT b.id, b.version, b.url, b.title, b.description, b.notes,
+			       (SELECT json_group_array(name) FROM (
+			            SELECT t.name
+			            FROM bookmark_tags b_t
+			            JOIN tags t ON b_t.tag_id = t.id AND t.deleted_at IS NULL
+			            WHERE b_t.bookmark_id = b.id
+			            ORDER BY t.name)) AS tags
+			FROM bookmarks b
+			WHERE b.id = ?
+		)
+		INSERT OR IGNORE INTO bookmark_revisions (bookmark_id, version, url, title, description, notes, tags)
+		SELECT id, version, url, title, description, notes, tags
+		FROM current c
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM bookmark_revisions r
+			WHERE r.id = (SELECT MAX(id) FROM bookmark_revisions WHERE bookmark_id = c.id)
+			  AND r.url IS c.url
+			  AND r.title IS c.title
+			  AND r.description IS c.description
+			  AND r.notes IS c.notes
+			  AND r.tags = c.tags
+		)`
+	GET_REVISIONS = `
+		SELECT r.version, r.url, r.title, r.description, r.notes, r.tags, r.created_at
+		FROM bookmark_revisions r
+		JOIN bookmarks b ON r.bookmark_id = b.id
+		WHERE r.bookmark_id = ? AND b.workspace_id = ? AND b.deleted_at IS NULL
+		ORDER BY r.version`
+)
+
+type fieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+type Revision struct {
+	Revision  int                    `json:"revision"`
+	CreatedAt time.Time              `json:"created_at"`
+	Changes   map[string]fieldChange `json:"changes"`
+}
+
+type revisionSnapshot struct {
+	bookmarkRequest
+	CreatedAt time.Time
+}
+
+// GetRevisionsHandler lists a bookmark's revisions, newest first, each with
+// the fields it changed compared to the revision before it.
+func GetRevisionsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		bookmarkWorkspaceId, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_VIEWER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.FindOne(findBookmark(db, id, bookmarkWorkspaceId), bookmarkScanner); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		snapshots, err := getRevisionSnapshots(db, bookmarkWorkspaceId, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		revisions := make([]Revision, len(snapshots))
+		previous := revisionSnapshot{}
+		for i, snapshot := range snapshots {
+			revisions[len(snapshots)-1-i] = Revision{
+				Revision:  snapshot.Version,
+				CreatedAt: snapshot.CreatedAt,
+				Changes:   diffSnapshots(previous, snapshot),
+			}
+			previous = snapshot
+		}
+
+		utils.WriteJSON(w, http.StatusOK, revisions)
+	}
+}
+
+// RestoreRevisionHandler rolls a bookmark back to the content it had at the
+// given revision. The rollback itself is recorded as a new revision.
+func RestoreRevisionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		revision, err := utils.PathId(r, "rev", "revision")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		bookmarkWorkspaceId, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_EDITOR)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		existingBookmark, err := utils.FindOne(findBookmark(db, id, bookmarkWorkspaceId), bookmarkScanner)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := utils.CheckIfMatch(r, utils.VersionETag(existingBookmark.Version)); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		snapshots, err := getRevisionSnapshots(db, bookmarkWorkspaceId, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		index := slices.IndexFunc(snapshots, func(s revisionSnapshot) bool {
+			return strconv.Itoa(s.Version) == revision
+		})
+		if index < 0 {
+			utils.WriteError(w, r, utils.NotFound("Revision not found"))
+			return
+		}
+
+		// Revisions only cover content, reading state stays as it is now.
+		snapshot := snapshots[index]
+		restored := bookmarkRequest{Bookmark: *existingBookmark, Tags: snapshot.Tags}
+		restored.Url = snapshot.Url
+		restored.Title = snapshot.Title
+		restored.Description = snapshot.Description
+		restored.Notes = snapshot.Notes
+		if errs := restored.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if err := saveBookmark(db, bookmarkWorkspaceId, string(userId), &restored, true, false); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeBookmark(w, r, db, bookmarkWorkspaceId, id, http.StatusOK)
+	}
+}
+
+func recordRevision(execer utils.Execer, bookmarkId int64) error {
+	_, err := utils.Exec(execer, RECORD_REVISION, bookmarkId)
+	return err
+}
+
+func getRevisionSnapshots(db *sql.DB, workspaceId, id string) ([]revisionSnapshot, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := db.Prepare(GET_REVISIONS)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(id, workspaceId)
+			return stmt, rows, err
+		},
+		revisionsScanner,
+	)
+}
+
+func revisionsScanner(rows *sql.Rows) ([]revisionSnapshot, error) {
+	result := []revisionSnapshot{}
+
+	for rows.Next() {
+		var snapshot revisionSnapshot
+		var title, description, notes sql.NullString
+		var tags string
+		err := rows.Scan(
+			&snapshot.Version,
+			&snapshot.Url,
+			&title,
+			&description,
+			&notes,
+			&tags,
+			&snapshot.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot.Title = title.String
+		snapshot.Description = description.String
+		snapshot.Notes = notes.String
+		if err := json.Unmarshal([]byte(tags), &snapshot.Tags); err != nil {
+			return nil, err
+		}
+
+		result = append(result, snapshot)
+	}
+
+	return result, rows.Err()
+}
+
+// diffSnapshots returns the fields that differ between two snapshots. Diffing
+// against the zero snapshot lists every field the first revision set.
+func diffSnapshots(from, to revisionSnapshot) map[string]fieldChange {
+	changes := map[string]fieldChange{}
+
+	stringFields := []struct {
+		name     string
+		from, to string
+	}{
+		{"url", from.Url, to.Url},
+		{"title", from.Title, to.Title},
+		{"description", from.Description, to.Description},
+		{"notes", from.Notes, to.Notes},
+	}
+	for _, field := range stringFields {
+		if field.from != field.to {
+			changes[field.name] = fieldChange{From: nullIfEmpty(field.from), To: nullIfEmpty(field.to)}
+		}
+	}
+
+	if !slices.Equal(from.Tags, to.Tags) {
+		fromTags, toTags := from.Tags, to.Tags
+		if fromTags == nil {
+			fromTags = []string{}
+		}
+		changes["tags"] = fieldChange{From: fromTags, To: toTags}
+	}
+
+	return changes
+}
+
+func nullIfEmpty(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,219 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"net/http"
+	"slices"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/rules"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/tags"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const MAX_RULE_PREVIEW = 100
+
+type rulePreview struct {
+	Matched int `json:"matched"`
+	// Changed counts the matching bookmarks that lack some of the rule's tags.
+	Changed int `json:"changed"`
+	// Bookmarks lists the newest matching bookmarks, up to MAX_RULE_PREVIEW.
+	Bookmarks []BookmarkWithTags `json:"bookmarks"`
+}
+
+type ruleApplyResult struct {
+	Matched int `json:"matched"`
+	Tagged  int `json:"tagged"`
+}
+
+// PreviewRuleHandler shows which of the workspace's bookmarks a rule matches
+// and how many of them applying it would tag.
+func PreviewRuleHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "rule")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		rule, err := rules.Find(db, id, workspaceId, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		matches, ruleTags, err := ruleMatches(db, workspaceId, rule)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		preview := rulePreview{Matched: len(matches), Bookmarks: matches[:min(len(matches), MAX_RULE_PREVIEW)]}
+		for _, bookmark := range matches {
+			if len(missingTags(bookmark.Tags, ruleTags)) > 0 {
+				preview.Changed++
+			}
+		}
+
+		utils.WriteJSON(w, http.StatusOK, preview)
+	}
+}
+
+// ApplyRuleHandler tags the workspace's bookmarks that match a rule with its
+// tags, whether or not the rule is enabled. Bookmarks keep their other tags,
+// and only get as many as the limit of tags per bookmark allows.
+func ApplyRuleHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "rule")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		rule, err := rules.Find(tx, id, workspaceId, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		matches, ruleTags, err := ruleMatches(tx, workspaceId, rule)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result := ruleApplyResult{Matched: len(matches)}
+		for _, bookmark := range matches {
+			missing := missingTags(bookmark.Tags, ruleTags)
+			missing = missing[:max(0, min(len(missing), tags.MAX_TAGS_PER_BOOKMARK-len(bookmark.Tags)))]
+			if len(missing) == 0 {
+				continue
+			}
+
+			if err := setBookmarkTags(tx, int64(bookmark.Id), missing, workspaceId, string(userId)); err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+			if _, err := utils.Exec(tx, utils.BUMP_BOOKMARK_VERSION, bookmark.Id, workspaceId); err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+			if err := recordRevision(tx, int64(bookmark.Id)); err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+			result.Tagged++
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, result)
+	}
+}
+
+// addRuleTags adds the tags of the user's matching rules to the bookmark's,
+// as far as the limit of tags per bookmark allows, and tells whether that
+// added any.
+func addRuleTags(execer utils.Execer, workspaceId string, userId utils.UserId, bookmark *bookmarkRequest) (bool, error) {
+	ruleTags, err := rules.MatchingTags(execer, workspaceId, userId, bookmark.Url, bookmark.Title)
+	if err != nil || len(ruleTags) == 0 {
+		return false, err
+	}
+
+	current, err := tags.ResolveAliases(execer, bookmark.Tags, workspaceId)
+	if err != nil {
+		return false, err
+	}
+	combined, err := tags.ResolveAliases(execer, append(slices.Clone(current), ruleTags...), workspaceId)
+	if err != nil {
+		return false, err
+	}
+
+	combined = combined[:min(len(combined), tags.MAX_TAGS_PER_BOOKMARK)]
+	if len(combined) == len(current) {
+		return false, nil
+	}
+	bookmark.Tags = combined
+	return true, nil
+}
+
+// ruleMatches lists the workspace's bookmarks outside the trash that match a
+// rule, newest first, together with the rule's tags with aliases resolved.
+func ruleMatches(execer utils.Execer, workspaceId string, rule *rules.Rule) ([]BookmarkWithTags, []string, error) {
+	rows, err := utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := execer.Prepare(`
+				SELECT ` + bookmarkColumns + `, t.name
+				FROM bookmarks b
+				` + liveTagsJoin + `
+				WHERE b.workspace_id = ? AND b.deleted_at IS NULL
+				ORDER BY b.created_at DESC, b.id DESC, t.name`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(workspaceId)
+			return stmt, rows, err
+		},
+		bookmarksScanner,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Matching before grouping the rows keeps grouping cheap.
+	rows = slices.DeleteFunc(rows, func(bookmark BookmarkWithTag) bool {
+		return !rule.Matches(bookmark.Url, bookmark.Title)
+	})
+
+	ruleTags, err := tags.ResolveAliases(execer, rule.Tags, workspaceId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return normalizeBookmarks(rows), ruleTags, nil
+}
+
+// missingTags returns the names among wanted that a bookmark with the given
+// tags doesn't have yet.
+func missingTags(current, wanted []string) []string {
+	missing := []string{}
+	for _, name := range wanted {
+		if !slices.ContainsFunc(current, func(tag string) bool { return tags.EqualNames(tag, name) }) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,151 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/collections"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const (
+	STATUS_UNREAD  = "unread"
+	STATUS_READING = "reading"
+	STATUS_READ    = "read"
+)
+
+// Values of the archived list filter. Archived bookmarks are left out of
+// listings unless they're explicitly asked for.
+const (
+	ARCHIVED_EXCLUDE = "exclude"
+	ARCHIVED_ONLY    = "only"
+	ARCHIVED_INCLUDE = "include"
+)
+
+const (
+	SET_BOOKMARK_STATUS   = `UPDATE bookmarks SET status = ?, read_at = CASE WHEN ? = 'read' THEN COALESCE(read_at, CURRENT_TIMESTAMP) END, version = version + 1 WHERE id = ? AND workspace_id = ? AND version = ? AND deleted_at IS NULL`
+	SET_BOOKMARK_FAVORITE = `UPDATE bookmarks SET is_favorite = ?, version = version + 1 WHERE id = ? AND workspace_id = ? AND version = ? AND deleted_at IS NULL`
+	SET_BOOKMARK_ARCHIVED = `UPDATE bookmarks SET is_archived = ?, version = version + 1 WHERE id = ? AND workspace_id = ? AND version = ? AND deleted_at IS NULL`
+)
+
+var bookmarkStatuses = []string{STATUS_UNREAD, STATUS_READING, STATUS_READ}
+
+func parseArchived(value string) string {
+	switch strings.ToLower(value) {
+	case "true", "only":
+		return ARCHIVED_ONLY
+	case "all", "include":
+		return ARCHIVED_INCLUDE
+	default:
+		return ARCHIVED_EXCLUDE
+	}
+}
+
+func validateStatus(status string) error {
+	if !slices.Contains(bookmarkStatuses, status) {
+		return fmt.Errorf("Status should be one of: %s", strings.Join(bookmarkStatuses, ", "))
+	}
+	return nil
+}
+
+// SetStatusHandler changes a bookmark's reading status. Marking it read stamps
+// read_at, moving it back to unread or reading clears it.
+func SetStatusHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		updateBookmarkState(w, r, db, SET_BOOKMARK_STATUS, func(r *http.Request) ([]any, error) {
+			body, err := utils.DecodeRequestBody[struct {
+				Status string `json:"status"`
+			}](r)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := validateStatus(body.Status); err != nil {
+				return nil, utils.ValidationFailed(utils.FieldErrors{"status": {err.Error()}})
+			}
+			return []any{body.Status, body.Status}, nil
+		})
+	}
+}
+
+// SetFavoriteHandler stars (POST) or unstars (DELETE) a bookmark.
+func SetFavoriteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		updateBookmarkState(w, r, db, SET_BOOKMARK_FAVORITE, methodValue)
+	}
+}
+
+// SetArchivedHandler archives (POST) or unarchives (DELETE) a bookmark.
+func SetArchivedHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		updateBookmarkState(w, r, db, SET_BOOKMARK_ARCHIVED, methodValue)
+	}
+}
+
+// methodValue sets a flag on POST and clears it on DELETE.
+func methodValue(r *http.Request) ([]any, error) {
+	return []any{r.Method == http.MethodPost}, nil
+}
+
+// updateBookmarkState runs one of the SET_BOOKMARK_* queries with the values
+// read from the request, followed by the bookmark's id, workspace and
+// expected version. The request is only read once its user is authenticated.
+func updateBookmarkState(w http.ResponseWriter, r *http.Request, db *sql.DB, query string, read func(*http.Request) ([]any, error)) {
+	id, err := utils.PathId(r, "id", "bookmark")
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	userId, err := utils.IsAuthenticated(r)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	workspaceId, err := workspaces.Current(db, r, userId)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	values, err := read(r)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	bookmarkWorkspaceId, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_EDITOR)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	bookmark, err := utils.FindOne(findBookmark(db, id, bookmarkWorkspaceId), bookmarkScanner)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if err := utils.CheckIfMatch(r, utils.VersionETag(bookmark.Version)); err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	result, err := utils.Exec(db, query, append(values, id, bookmarkWorkspaceId, bookmark.Version)...)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	if err := requireAffected(result); err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	writeBookmark(w, r, db, bookmarkWorkspaceId, strconv.Itoa(bookmark.Id), http.StatusOK)
+}
@@ -0,0 +1,156 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/pages"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/tags"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+// EXPAND_SUGGESTED_TAGS is the ?expand value asking for tag suggestions on a
+// single bookmark.
+const EXPAND_SUGGESTED_TAGS = "suggested_tags"
+
+type suggestTagsRequest struct {
+	Url         string   `json:"url"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	// Fetch asks for the page at Url to be downloaded and its text scored as
+	// well. Nothing leaves the server unless it is set.
+	Fetch bool `json:"fetch"`
+	Limit int  `json:"limit"`
+}
+
+type suggestTagsResponse struct {
+	// Fetched tells whether the page text could be scored.
+	Fetched     bool              `json:"fetched"`
+	Suggestions []tags.Suggestion `json:"suggestions"`
+}
+
+// validate normalizes the request in place and returns every rule it breaks.
+func (s *suggestTagsRequest) validate() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	s.Url = strings.TrimSpace(s.Url)
+	s.Title = strings.TrimSpace(s.Title)
+	s.Tags = tags.NormalizeNames(s.Tags)
+	if s.Url == "" && s.Title == "" && s.Description == "" && len(s.Tags) == 0 {
+		errs.Add("url", "At least one of url, title, description or tags is required")
+	}
+
+	if s.Url != "" {
+		if err := validateUrl(s.Url); err != nil {
+			errs.Add("url", err.Error())
+		}
+	}
+
+	if utf8.RuneCountInString(s.Title) > MAX_TITLE_LENGTH {
+		errs.Add("title", fmt.Sprintf("Title should be no longer than %d characters", MAX_TITLE_LENGTH))
+	}
+
+	if utf8.RuneCountInString(s.Description) > MAX_DESCRIPTION_LENGTH {
+		errs.Add("description", fmt.Sprintf("Description should be no longer than %d characters", MAX_DESCRIPTION_LENGTH))
+	}
+
+	errs.Merge(tags.ValidateNames("tags", s.Tags))
+
+	if s.Limit < 0 || s.Limit > tags.MAX_SUGGESTION_LIMIT {
+		errs.Add("limit", fmt.Sprintf("Limit should be between 1 and %d", tags.MAX_SUGGESTION_LIMIT))
+	}
+
+	return errs
+}
+
+// SuggestTagsHandler proposes tags from the workspace for a bookmark being
+// created, scoring its url, title, description and, when asked to, the text
+// of its page. A page that can't be fetched doesn't fail the request;
+// the suggestions then leave its text out.
+func SuggestTagsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[suggestTagsRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if errs := request.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		doc := tags.Document{
+			Url:         request.Url,
+			Title:       request.Title,
+			Description: request.Description,
+			Tags:        request.Tags,
+		}
+
+		response := suggestTagsResponse{}
+		if request.Url != "" && request.Fetch {
+			text, err := pages.FetchText(r.Context(), request.Url)
+			if err != nil {
+				log.Printf("Couldn't fetch %s to suggest tags: %v", request.Url, err)
+			} else {
+				doc.Text = text
+				response.Fetched = true
+			}
+		}
+
+		limit := tags.DEFAULT_SUGGESTION_LIMIT
+		if request.Limit > 0 {
+			limit = request.Limit
+		}
+
+		response.Suggestions, err = tags.Suggest(db, workspaceId, doc, limit)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, response)
+	}
+}
+
+// expandSuggestedTags fills in tag suggestions for a bookmark when the
+// request asks for them with ?expand=suggested_tags. Only what's stored on
+// the bookmark is scored; its page isn't fetched.
+func expandSuggestedTags(execer utils.Execer, r *http.Request, workspaceId string, bookmark *BookmarkWithTags) error {
+	if !slices.Contains(strings.Split(r.URL.Query().Get("expand"), ","), EXPAND_SUGGESTED_TAGS) {
+		return nil
+	}
+
+	suggestions, err := tags.Suggest(execer, workspaceId, tags.Document{
+		BookmarkId:  bookmark.Id,
+		Url:         bookmark.Url,
+		Title:       bookmark.Title,
+		Description: bookmark.Description,
+		Tags:        bookmark.Tags,
+	}, tags.DEFAULT_SUGGESTION_LIMIT)
+	if err != nil {
+		return err
+	}
+
+	bookmark.SuggestedTags = suggestions
+	return nil
+}
@@ -0,0 +1,303 @@
+package bookmarks
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/collections"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/tags"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+// GetTagBookmarksHandler lists the workspace's bookmarks with a tag. It takes
+// the same filters, sorting and pagination as the bookmarks list.
+func GetTagBookmarksHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "tag")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := tags.Find(db, id, workspaceId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		queryParams := getQueryParams(r)
+		queryParams.tag = id
+
+		bookmarks, err := utils.FindMany(
+			bookmarksListQueryRunner(db, workspaceId, queryParams),
+			bookmarksScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeBookmarks(w, r, db, normalizeBookmarks(bookmarks))
+	}
+}
+
+// EXPAND_TAGS is the ?expand value asking for full tags next to their names.
+const EXPAND_TAGS = "tags"
+
+func writeBookmarks(w http.ResponseWriter, r *http.Request, db *sql.DB, bookmarks []BookmarkWithTags) {
+	expanded := make([]*BookmarkWithTags, len(bookmarks))
+	for i := range bookmarks {
+		expanded[i] = &bookmarks[i]
+	}
+	if err := expandTags(db, r, expanded); err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, bookmarks)
+}
+
+// expandTags fills in the full tags of the bookmarks when the request asks for
+// them with ?expand=tags.
+func expandTags(execer utils.Execer, r *http.Request, bookmarks []*BookmarkWithTags) error {
+	if !slices.Contains(strings.Split(r.URL.Query().Get("expand"), ","), EXPAND_TAGS) {
+		return nil
+	}
+
+	ids := make([]int, len(bookmarks))
+	for i, bookmark := range bookmarks {
+		ids[i] = bookmark.Id
+	}
+
+	bookmarkTags, err := tags.ForBookmarks(execer, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, bookmark := range bookmarks {
+		bookmark.TagDetails = bookmarkTags[bookmark.Id]
+		if bookmark.TagDetails == nil {
+			bookmark.TagDetails = []tags.Tag{}
+		}
+	}
+	return nil
+}
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// tagEditsRequest adds and removes tags on many bookmarks at once, picked
+// like the bookmarks of the bulk endpoint.
+type tagEditsRequest struct {
+	Ids    []int       `json:"ids"`
+	Filter *bulkFilter `json:"filter"`
+	Add    []string    `json:"add"`
+	Remove []string    `json:"remove"`
+	DryRun bool        `json:"dry_run"`
+}
+
+// AddBookmarkTagsHandler tags a bookmark with more tags, keeping the ones it
+// has. Tags it already has are skipped, so repeating the request is safe and
+// concurrent edits of other tags aren't lost.
+func AddBookmarkTagsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		editBookmarkTagsHandler(w, r, db, func(r *http.Request) ([]string, []string, error) {
+			request, err := utils.DecodeRequestBody[tagsRequest](r)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			request.Tags = tags.NormalizeNames(request.Tags)
+			errs := tags.ValidateNames("tags", request.Tags)
+			if len(request.Tags) == 0 {
+				errs.Add("tags", "At least one tag is required")
+			}
+			if errs.HasErrors() {
+				return nil, nil, utils.ValidationFailed(errs)
+			}
+			return request.Tags, nil, nil
+		})
+	}
+}
+
+// RemoveBookmarkTagHandler takes one tag, or the tag one of its aliases
+// stands for, off a bookmark, keeping the others. Nested tag names keep their
+// slashes in the path.
+func RemoveBookmarkTagHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		editBookmarkTagsHandler(w, r, db, func(r *http.Request) ([]string, []string, error) {
+			names := tags.NormalizeNames([]string{r.PathValue("name")})
+			if len(names) == 0 {
+				return nil, nil, utils.NotFound("Tag not found")
+			}
+			return nil, names, nil
+		})
+	}
+}
+
+// editBookmarkTagsHandler adds and removes the tags edits reads from the
+// request on the bookmark in the path, checking If-Match when it's sent, and
+// responds with the bookmark. The request is only read once its user is
+// authenticated.
+func editBookmarkTagsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, edits func(*http.Request) (add, remove []string, err error)) {
+	id, err := utils.PathId(r, "id", "bookmark")
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	userId, err := utils.IsAuthenticated(r)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	workspaceId, err := workspaces.Current(db, r, userId)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	add, remove, err := edits(r)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	bookmarkWorkspaceId, err := bookmarkWorkspace(db, id, workspaceId, userId, collections.ROLE_EDITOR)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	bookmark, err := utils.FindOne(findBookmark(tx, id, bookmarkWorkspaceId), bookmarkScanner)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if err := utils.CheckIfMatch(r, utils.VersionETag(bookmark.Version)); err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if add, err = tags.ResolveAliases(tx, add, bookmarkWorkspaceId); err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	if remove, err = tags.ResolveAliases(tx, remove, bookmarkWorkspaceId); err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	changed, err := editBookmarkTags(tx, bookmarkWorkspaceId, string(userId), bookmark.Id, add, remove)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	if !changed && len(remove) > 0 {
+		utils.WriteError(w, r, utils.NotFound(fmt.Sprintf("The bookmark isn't tagged with %q", remove[0])))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	writeBookmark(w, r, db, bookmarkWorkspaceId, id, http.StatusOK)
+}
+
+// EditTagsHandler adds and removes tags on many of the workspace's bookmarks
+// in a single transaction, leaving their other tags alone. It is the bulk
+// endpoint's edit_tags action: bookmarks that can't be edited, such as ones
+// that would end up with too many tags, are reported per item without
+// stopping the others.
+func EditTagsHandler(db *sql.DB) http.HandlerFunc {
+	return bulkHandler(db, func(r *http.Request) (*bulkRequest, error) {
+		edits, err := utils.DecodeRequestBody[tagEditsRequest](r)
+		if err != nil {
+			return nil, err
+		}
+		return &bulkRequest{
+			Action: BULK_EDIT_TAGS,
+			Ids:    edits.Ids,
+			Filter: edits.Filter,
+			Add:    edits.Add,
+			Remove: edits.Remove,
+			DryRun: edits.DryRun,
+		}, nil
+	})
+}
+
+func checkNotBothAddedAndRemoved(add, remove []string) utils.FieldErrors {
+	errs := utils.FieldErrors{}
+	for _, name := range add {
+		if slices.ContainsFunc(remove, func(removed string) bool { return tags.EqualNames(removed, name) }) {
+			errs.Add("remove", fmt.Sprintf("Tag %q can't be both added and removed", name))
+		}
+	}
+	return errs
+}
+
+// editBookmarkTags adds and removes tags of a bookmark of the workspace,
+// leaving its other tags alone, and tells whether that changed anything.
+// Names are expected with aliases resolved. Only actual changes bump the
+// bookmark's version and record a revision.
+func editBookmarkTags(tx *sql.Tx, workspaceId, userId string, bookmarkId int, add, remove []string) (bool, error) {
+	bookmarkTags, err := tags.ForBookmarks(tx, []int{bookmarkId})
+	if err != nil {
+		return false, err
+	}
+	current := make([]string, len(bookmarkTags[bookmarkId]))
+	for i, tag := range bookmarkTags[bookmarkId] {
+		current[i] = tag.Name
+	}
+
+	add = missingTags(current, add)
+	remove = slices.DeleteFunc(slices.Clone(remove), func(name string) bool {
+		return len(missingTags(current, []string{name})) > 0
+	})
+	if len(add) == 0 && len(remove) == 0 {
+		return false, nil
+	}
+
+	if len(current)-len(remove)+len(add) > tags.MAX_TAGS_PER_BOOKMARK {
+		return false, utils.BadRequest(fmt.Sprintf("A bookmark can have at most %d tags", tags.MAX_TAGS_PER_BOOKMARK))
+	}
+
+	if err := tags.RemoveBookmarkTags(tx, int64(bookmarkId), remove, workspaceId); err != nil {
+		return false, err
+	}
+	if err := setBookmarkTags(tx, int64(bookmarkId), add, workspaceId, userId); err != nil {
+		return false, err
+	}
+
+	if _, err := utils.Exec(tx, utils.BUMP_BOOKMARK_VERSION, bookmarkId, workspaceId); err != nil {
+		return false, err
+	}
+	return true, recordRevision(tx, int64(bookmarkId))
+}
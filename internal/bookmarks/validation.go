@@ -0,0 +1,78 @@
+package bookmarks
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/tags"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+const (
+	MAX_TITLE_LENGTH       = 500
+	MAX_DESCRIPTION_LENGTH = 2000
+	MAX_NOTES_LENGTH       = 5000
+)
+
+type bookmarkRequest struct {
+	Bookmark
+	Tags []string `json:"tags"`
+}
+
+// validate normalizes the request in place and returns every rule it breaks.
+func (b *bookmarkRequest) validate() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	b.Url = strings.TrimSpace(b.Url)
+	if err := validateUrl(b.Url); err != nil {
+		errs.Add("url", err.Error())
+	}
+
+	b.Title = strings.TrimSpace(b.Title)
+	if utf8.RuneCountInString(b.Title) > MAX_TITLE_LENGTH {
+		errs.Add("title", fmt.Sprintf("Title should be no longer than %d characters", MAX_TITLE_LENGTH))
+	}
+
+	if utf8.RuneCountInString(b.Description) > MAX_DESCRIPTION_LENGTH {
+		errs.Add("description", fmt.Sprintf("Description should be no longer than %d characters", MAX_DESCRIPTION_LENGTH))
+	}
+
+	if utf8.RuneCountInString(b.Notes) > MAX_NOTES_LENGTH {
+		errs.Add("notes", fmt.Sprintf("Notes should be no longer than %d characters", MAX_NOTES_LENGTH))
+	}
+
+	if b.Status == "" {
+		b.Status = STATUS_UNREAD
+	}
+	if err := validateStatus(b.Status); err != nil {
+		errs.Add("status", err.Error())
+	}
+
+	b.Tags = tags.NormalizeNames(b.Tags)
+	errs.Merge(tags.ValidateNames("tags", b.Tags))
+
+	return errs
+}
+
+func validateUrl(rawUrl string) error {
+	if rawUrl == "" {
+		return fmt.Errorf("URL is required")
+	}
+
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return fmt.Errorf("Invalid URL: %s", rawUrl)
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("URL should use the http or https scheme")
+	}
+
+	if parsed.Host == "" || parsed.Hostname() == "" {
+		return fmt.Errorf("URL should include a host")
+	}
+
+	return nil
+}
@@ -0,0 +1,380 @@
+package collections
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const MAX_COLLECTION_NAME_LENGTH = 100
+
+const (
+	CREATE_COLLECTION = `INSERT INTO collections (user_id, workspace_id, parent_id, name) VALUES(?, ?, ?, ?)`
+	RENAME_COLLECTION = `UPDATE collections SET name = ? WHERE id = ?`
+	DELETE_COLLECTION = `DELETE FROM collections WHERE id = ?`
+	// BUMP_COLLECTION_BOOKMARKS bumps the bookmarks filed anywhere in a
+	// collection's subtree, whose collection_id is about to change.
+	BUMP_COLLECTION_BOOKMARKS = `
+		WITH RECURSIVE subtree(id) AS (
+			SELECT ?
+			UNION
+			SELECT c.id FROM collections c JOIN subtree s ON c.parent_id = s.id
+		)
+		UPDATE bookmarks SET version = version + 1
+		WHERE id IN (SELECT bookmark_id FROM collection_bookmarks WHERE collection_id IN (SELECT id FROM subtree))`
+)
+
+// collectionColumns lists the columns scanned by Collection.scanFields, for
+// collections aliased as c. Bookmarks in the trash are not counted.
+const collectionColumns = `c.id, c.user_id, c.workspace_id, c.parent_id, c.name, c.position,
+	(SELECT COUNT(*)
+	 FROM collection_bookmarks c_b
+	 JOIN bookmarks b ON c_b.bookmark_id = b.id AND b.deleted_at IS NULL
+	 WHERE c_b.collection_id = c.id),
+	c.created_at, c.updated_at`
+
+type Collection struct {
+	Id            int    `json:"id"`
+	OwnerId       int    `json:"owner_id"`
+	WorkspaceId   int    `json:"workspace_id"`
+	ParentId      *int   `json:"parent_id"`
+	Name          string `json:"name"`
+	Position      int    `json:"position"`
+	BookmarkCount int    `json:"bookmark_count"`
+	// Role is the one the requesting user holds on the collection.
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type collectionRequest struct {
+	Name     string `json:"name"`
+	ParentId *int   `json:"parent_id"`
+	Position *int   `json:"position"`
+}
+
+// GetCollectionsHandler lists the collections of the current workspace and
+// those shared with the user as a flat list, ordered by parent and position.
+// Clients rebuild the tree from parent_id, treating collections whose parent
+// isn't listed as roots.
+func GetCollectionsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		collections, err := utils.FindMany(
+			func() (*sql.Stmt, *sql.Rows, error) {
+				stmt, err := db.Prepare(ACCESSIBLE_COLLECTIONS + `
+					SELECT ` + collectionColumns + `, MAX(a.rank)
+					FROM collections c
+					JOIN accessible a ON a.id = c.id
+					GROUP BY c.id
+					ORDER BY c.parent_id NULLS FIRST, c.position, c.id`)
+				if err != nil {
+					return nil, nil, err
+				}
+				rows, err := stmt.Query(workspaceId, userId)
+				return stmt, rows, err
+			},
+			collectionsScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, collections)
+	}
+}
+
+func GetCollectionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		collection, err := Find(db, id, workspaceId, string(userId), ROLE_VIEWER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, collection)
+	}
+}
+
+// CreateCollectionHandler creates a collection under parent_id, which needs
+// the editor role, or at the top level of the current workspace when it's
+// omitted. Collections belong to the same workspace as their parent. Without
+// a position it's placed after its siblings.
+func CreateCollectionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[collectionRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		name, errs := validateName(request.Name)
+		request.Name = name
+		if request.Position != nil && *request.Position < 0 {
+			errs.Add("position", "Position should not be negative")
+		}
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		parent, err := checkParent(tx, workspaceId, string(userId), request.ParentId, nil)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		collectionWorkspaceId := workspaceId
+		if parent != nil {
+			collectionWorkspaceId = strconv.Itoa(parent.WorkspaceId)
+		}
+
+		result, err := utils.Exec(tx, CREATE_COLLECTION, userId, collectionWorkspaceId, request.ParentId, request.Name)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		collectionId, err := result.LastInsertId()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := placeCollection(tx, collectionWorkspaceId, int(collectionId), request.ParentId, request.Position); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		id := strconv.FormatInt(collectionId, 10)
+		collection, err := Find(db, id, workspaceId, string(userId), ROLE_VIEWER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", "/api/collections/"+id)
+		utils.WriteJSON(w, http.StatusCreated, collection)
+	}
+}
+
+// RenameCollectionHandler changes a collection's name, which editors may do.
+// Moving it around is done through MoveCollectionHandler.
+func RenameCollectionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[collectionRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		name, errs := validateName(request.Name)
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if _, err := Find(db, id, workspaceId, string(userId), ROLE_EDITOR); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(db, RENAME_COLLECTION, name, id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		collection, err := Find(db, id, workspaceId, string(userId), ROLE_VIEWER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, collection)
+	}
+}
+
+// DeleteCollectionHandler deletes a collection along with everything nested
+// in it. The bookmarks filed there are kept, they just no longer belong to a
+// collection. Only owners can delete a collection.
+func DeleteCollectionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := Find(tx, id, workspaceId, string(userId), ROLE_OWNER); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(tx, BUMP_COLLECTION_BOOKMARKS, id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(tx, DELETE_COLLECTION, id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func validateName(name string) (string, utils.FieldErrors) {
+	errs := utils.FieldErrors{}
+
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		errs.Add("name", "Name is required")
+	}
+	if utf8.RuneCountInString(name) > MAX_COLLECTION_NAME_LENGTH {
+		errs.Add("name", fmt.Sprintf("Name should be no longer than %d characters", MAX_COLLECTION_NAME_LENGTH))
+	}
+
+	return name, errs
+}
+
+// collectionScanner leaves Role empty, it depends on who's asking.
+func collectionScanner(row *sql.Row) (*Collection, error) {
+	var collection Collection
+	if err := row.Scan(collection.scanFields()...); err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+func collectionsScanner(rows *sql.Rows) ([]Collection, error) {
+	result := []Collection{}
+
+	for rows.Next() {
+		var collection Collection
+		var rank int
+		if err := rows.Scan(append(collection.scanFields(), &rank)...); err != nil {
+			return nil, err
+		}
+		collection.Role = roleOfRank(rank)
+		result = append(result, collection)
+	}
+
+	return result, rows.Err()
+}
+
+func (c *Collection) scanFields() []any {
+	return []any{
+		&c.Id,
+		&c.OwnerId,
+		&c.WorkspaceId,
+		&c.ParentId,
+		&c.Name,
+		&c.Position,
+		&c.BookmarkCount,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	}
+}
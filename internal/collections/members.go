@@ -0,0 +1,357 @@
+package collections
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const (
+	// COLLECTION_MEMBERS lists the collection's creator, who is an owner as
+	// long as they belong to its workspace, followed by the users it was
+	// shared with.
+	COLLECTION_MEMBERS = `
+		SELECT u.id, u.username, 'owner', c.created_at, TRUE
+		FROM collections c
+		JOIN users u ON c.user_id = u.id
+		JOIN workspace_members w_m ON w_m.workspace_id = c.workspace_id AND w_m.user_id = c.user_id
+		WHERE c.id = ?
+		UNION ALL
+		SELECT * FROM (
+			SELECT u.id, u.username, m.role, m.created_at, FALSE
+			FROM collection_members m JOIN users u ON m.user_id = u.id
+			WHERE m.collection_id = ?
+			ORDER BY m.created_at, u.id
+		)`
+	FIND_USER_ID  = `SELECT id FROM users WHERE username = ?`
+	ADD_MEMBER    = `INSERT INTO collection_members (collection_id, user_id, role) VALUES(?, ?, ?)`
+	SET_MEMBER    = `UPDATE collection_members SET role = ? WHERE collection_id = ? AND user_id = ?`
+	REMOVE_MEMBER = `DELETE FROM collection_members WHERE collection_id = ? AND user_id = ?`
+	IS_MEMBER     = `SELECT EXISTS (SELECT 1 FROM collection_members WHERE collection_id = ? AND user_id = ?)`
+)
+
+type Member struct {
+	UserId    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	// Creator marks the user who created the collection. They can't be
+	// removed nor lose the owner role.
+	Creator bool `json:"creator"`
+}
+
+type memberRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// GetMembersHandler lists who the collection is shared with, for anyone who
+// can see it. Members of parent collections aren't listed, even though their
+// roles carry over.
+func GetMembersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := Find(db, id, workspaceId, string(userId), ROLE_VIEWER); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		members, err := getMembers(db, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, members)
+	}
+}
+
+// AddMemberHandler shares a collection with a user, or changes the role of a
+// user it's already shared with. Only owners can manage members.
+func AddMemberHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[memberRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		errs := utils.FieldErrors{}
+		if request.Username == "" {
+			errs.Add("username", "Username is required")
+		}
+		if err := validateRole(request.Role); err != nil {
+			errs.Add("role", err.Error())
+		}
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		collection, err := Find(tx, id, workspaceId, string(userId), ROLE_OWNER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		memberId, err := findUserId(tx, request.Username)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if memberId == collection.OwnerId {
+			utils.WriteError(w, r, utils.ValidationFailed(utils.FieldErrors{"username": {"The collection's creator is always one of its owners"}}))
+			return
+		}
+
+		status := http.StatusOK
+		isMember, err := checkMember(tx, id, memberId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if isMember {
+			_, err = utils.Exec(tx, SET_MEMBER, request.Role, id, memberId)
+		} else {
+			_, err = utils.Exec(tx, ADD_MEMBER, id, memberId, request.Role)
+			status = http.StatusCreated
+		}
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeMember(w, r, db, id, memberId, status)
+	}
+}
+
+// UpdateMemberHandler changes the role of a user the collection is shared with.
+func UpdateMemberHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		memberId, err := utils.PathId(r, "userId", "user")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[memberRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := validateRole(request.Role); err != nil {
+			utils.WriteError(w, r, utils.ValidationFailed(utils.FieldErrors{"role": {err.Error()}}))
+			return
+		}
+
+		if _, err := Find(db, id, workspaceId, string(userId), ROLE_OWNER); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, SET_MEMBER, request.Role, id, memberId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Member not found"))
+			return
+		}
+
+		memberIdNumber, _ := strconv.Atoi(memberId)
+		writeMember(w, r, db, id, memberIdNumber, http.StatusOK)
+	}
+}
+
+// RemoveMemberHandler stops sharing a collection with a user. Owners can
+// remove anyone but the creator, and members can remove themselves.
+func RemoveMemberHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		memberId, err := utils.PathId(r, "userId", "user")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		required := ROLE_OWNER
+		if memberId == string(userId) {
+			required = ROLE_VIEWER
+		}
+		if _, err := Find(db, id, workspaceId, string(userId), required); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, REMOVE_MEMBER, id, memberId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Member not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeMember(w http.ResponseWriter, r *http.Request, db *sql.DB, collectionId string, memberId int, status int) {
+	members, err := getMembers(db, collectionId)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	for _, member := range members {
+		if member.UserId == memberId && !member.Creator {
+			utils.WriteJSON(w, status, member)
+			return
+		}
+	}
+	utils.WriteError(w, r, utils.NotFound("Member not found"))
+}
+
+func getMembers(db *sql.DB, collectionId string) ([]Member, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := db.Prepare(COLLECTION_MEMBERS)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(collectionId, collectionId)
+			return stmt, rows, err
+		},
+		membersScanner,
+	)
+}
+
+func membersScanner(rows *sql.Rows) ([]Member, error) {
+	result := []Member{}
+
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.UserId, &member.Username, &member.Role, &member.CreatedAt, &member.Creator); err != nil {
+			return nil, err
+		}
+		result = append(result, member)
+	}
+
+	return result, rows.Err()
+}
+
+func findUserId(execer utils.Execer, username string) (int, error) {
+	stmt, err := execer.Prepare(FIND_USER_ID)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var id int
+	err = stmt.QueryRow(username).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, utils.ValidationFailed(utils.FieldErrors{"username": {"User not found"}})
+	}
+	return id, err
+}
+
+func checkMember(execer utils.Execer, collectionId string, userId int) (bool, error) {
+	stmt, err := execer.Prepare(IS_MEMBER)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	var isMember bool
+	err = stmt.QueryRow(collectionId, userId).Scan(&isMember)
+	return isMember, err
+}
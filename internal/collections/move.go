@@ -0,0 +1,432 @@
+package collections
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const MAX_FILED_BOOKMARKS = 1000
+
+const (
+	// IS_IN_SUBTREE tells whether the first collection is the second one or
+	// sits somewhere below it, by walking up from the first one.
+	IS_IN_SUBTREE = `
+		WITH RECURSIVE ancestors(id) AS (
+			SELECT ?
+			UNION
+			SELECT c.parent_id FROM collections c JOIN ancestors a ON c.id = a.id WHERE c.parent_id IS NOT NULL
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = ?)`
+	SET_COLLECTION_PARENT   = `UPDATE collections SET parent_id = ? WHERE id = ?`
+	SET_COLLECTION_POSITION = `UPDATE collections SET position = ? WHERE id = ?`
+	// COLLECTION_SIBLINGS lists the children of a parent, or a workspace's
+	// top level collections when the parent is NULL.
+	COLLECTION_SIBLINGS = `
+		SELECT id FROM collections
+		WHERE parent_id IS ? AND id <> ? AND (parent_id IS NOT NULL OR workspace_id = ?)
+		ORDER BY position, id`
+	FILE_BOOKMARK = `
+		INSERT INTO collection_bookmarks (bookmark_id, collection_id) VALUES(?, ?)
+		ON CONFLICT(bookmark_id) DO UPDATE SET collection_id = excluded.collection_id`
+	// BUMP_REFILED_BOOKMARK bumps a bookmark unless it's already in the collection.
+	BUMP_REFILED_BOOKMARK = `
+		UPDATE bookmarks SET version = version + 1
+		WHERE id = ? AND workspace_id = ? AND deleted_at IS NULL
+		  AND id NOT IN (SELECT bookmark_id FROM collection_bookmarks WHERE collection_id = ?)`
+	BUMP_UNFILED_BOOKMARK   = `UPDATE bookmarks SET version = version + 1 WHERE id = ?`
+	UNFILE_BOOKMARK         = `DELETE FROM collection_bookmarks WHERE bookmark_id = ? AND collection_id = ?`
+	SET_BOOKMARK_POSITION   = `UPDATE collection_bookmarks SET position = ? WHERE bookmark_id = ?`
+	COLLECTION_BOOKMARK_IDS = `SELECT bookmark_id FROM collection_bookmarks WHERE collection_id = ? ORDER BY position, bookmark_id`
+)
+
+type moveRequest struct {
+	ParentId *int `json:"parent_id"`
+	Position *int `json:"position"`
+}
+
+type fileBookmarksRequest struct {
+	BookmarkIds []int `json:"bookmark_ids"`
+	Position    *int  `json:"position"`
+}
+
+// MoveCollectionHandler moves a collection, with everything nested in it,
+// under parent_id (or to the top level when it's null) at the given position
+// among its new siblings. A collection can't be moved into its own subtree
+// nor to another workspace. Moving needs the owner role on the collection and
+// the editor role on the new parent.
+func MoveCollectionHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[moveRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if request.Position != nil && *request.Position < 0 {
+			utils.WriteError(w, r, utils.ValidationFailed(utils.FieldErrors{"position": {"Position should not be negative"}}))
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		collection, err := Find(tx, id, workspaceId, string(userId), ROLE_OWNER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := checkParent(tx, workspaceId, string(userId), request.ParentId, collection); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(tx, SET_COLLECTION_PARENT, request.ParentId, id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := placeCollection(tx, strconv.Itoa(collection.WorkspaceId), collection.Id, request.ParentId, request.Position); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		collection, err = Find(db, id, workspaceId, string(userId), ROLE_VIEWER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, collection)
+	}
+}
+
+// FileBookmarksHandler puts bookmarks into a collection, in the given order,
+// at position among the bookmarks already there. Bookmarks filed elsewhere are
+// moved, since a bookmark belongs to at most one collection. Editors can file
+// bookmarks from the workspace they act on and rearrange the ones already in
+// the collection.
+func FileBookmarksHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[fileBookmarksRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		errs := utils.FieldErrors{}
+		if len(request.BookmarkIds) == 0 {
+			errs.Add("bookmark_ids", "At least one bookmark id is required")
+		}
+		if len(request.BookmarkIds) > MAX_FILED_BOOKMARKS {
+			errs.Add("bookmark_ids", fmt.Sprintf("At most %d bookmarks can be filed per request", MAX_FILED_BOOKMARKS))
+		}
+		if request.Position != nil && *request.Position < 0 {
+			errs.Add("position", "Position should not be negative")
+		}
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		collection, err := Find(tx, id, workspaceId, string(userId), ROLE_EDITOR)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := FileBookmarks(tx, workspaceId, collection.Id, request.BookmarkIds, request.Position); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UnfileBookmarkHandler takes a bookmark out of a collection. The bookmark
+// itself is kept.
+func UnfileBookmarkHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "collection")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		bookmarkId, err := utils.PathId(r, "bookmarkId", "bookmark")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := Find(tx, id, workspaceId, string(userId), ROLE_EDITOR); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(tx, UNFILE_BOOKMARK, bookmarkId, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Bookmark not found in collection"))
+			return
+		}
+
+		if _, err := utils.Exec(tx, BUMP_UNFILED_BOOKMARK, bookmarkId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// FileBookmarks moves bookmarks into a collection the caller has already
+// checked, keeping their order and inserting them at position among the
+// bookmarks already there, or after them when position is nil. Each bookmark
+// must either be in the given workspace or already be in the collection.
+func FileBookmarks(execer utils.Execer, workspaceId string, collectionId int, bookmarkIds []int, position *int) error {
+	moved := []int{}
+	for _, bookmarkId := range bookmarkIds {
+		if slices.Contains(moved, bookmarkId) {
+			continue
+		}
+
+		// Bumping doubles as the ownership check: it only matches live
+		// bookmarks of the workspace that aren't in the collection yet.
+		result, err := utils.Exec(execer, BUMP_REFILED_BOOKMARK, bookmarkId, workspaceId, collectionId)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			if err := checkBookmark(execer, workspaceId, collectionId, bookmarkId); err != nil {
+				return err
+			}
+		}
+
+		if _, err := utils.Exec(execer, FILE_BOOKMARK, bookmarkId, collectionId); err != nil {
+			return err
+		}
+		moved = append(moved, bookmarkId)
+	}
+
+	filed, err := utils.FindMany(idsQueryRunner(execer, COLLECTION_BOOKMARK_IDS, collectionId), idsScanner)
+	if err != nil {
+		return err
+	}
+	filed = slices.DeleteFunc(filed, func(id int) bool { return slices.Contains(moved, id) })
+
+	return reorder(execer, SET_BOOKMARK_POSITION, insertAt(filed, moved, position))
+}
+
+// checkParent makes sure parentId, when set, is a collection the user can
+// edit and returns it. When moving a collection, the parent must also be in
+// the same workspace and not in the collection's subtree.
+func checkParent(execer utils.Execer, workspaceId, userId string, parentId *int, collection *Collection) (*Collection, error) {
+	if parentId == nil {
+		return nil, nil
+	}
+
+	parent, err := Find(execer, strconv.Itoa(*parentId), workspaceId, userId, ROLE_EDITOR)
+	if err != nil {
+		var apiErr *utils.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == utils.NOT_FOUND {
+			return nil, utils.ValidationFailed(utils.FieldErrors{"parent_id": {"Parent collection not found"}})
+		}
+		return nil, err
+	}
+
+	if collection == nil {
+		return parent, nil
+	}
+	if parent.WorkspaceId != collection.WorkspaceId {
+		return nil, utils.ValidationFailed(utils.FieldErrors{"parent_id": {"A collection can't be moved to another workspace"}})
+	}
+
+	stmt, err := execer.Prepare(IS_IN_SUBTREE)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var cycle bool
+	if err := stmt.QueryRow(*parentId, collection.Id).Scan(&cycle); err != nil {
+		return nil, err
+	}
+	if cycle {
+		return nil, utils.ValidationFailed(utils.FieldErrors{"parent_id": {"A collection can't be moved into itself or one of its descendants"}})
+	}
+	return parent, nil
+}
+
+func checkBookmark(execer utils.Execer, workspaceId string, collectionId, bookmarkId int) error {
+	stmt, err := execer.Prepare(`
+		SELECT id FROM bookmarks
+		WHERE id = ? AND deleted_at IS NULL
+		  AND (workspace_id = ? OR id IN (SELECT bookmark_id FROM collection_bookmarks WHERE collection_id = ?))`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var id int
+	err = stmt.QueryRow(bookmarkId, workspaceId, collectionId).Scan(&id)
+	if err == sql.ErrNoRows {
+		return utils.NotFound(fmt.Sprintf("Bookmark %d not found", bookmarkId))
+	}
+	return err
+}
+
+// placeCollection puts a collection at position among the other children of
+// parentId, or among its workspace's top level collections, and renumbers them all.
+func placeCollection(execer utils.Execer, workspaceId string, id int, parentId *int, position *int) error {
+	siblings, err := utils.FindMany(idsQueryRunner(execer, COLLECTION_SIBLINGS, parentId, id, workspaceId), idsScanner)
+	if err != nil {
+		return err
+	}
+
+	return reorder(execer, SET_COLLECTION_POSITION, insertAt(siblings, []int{id}, position))
+}
+
+// insertAt returns ids with inserted spliced in at position, or appended when
+// position is nil or past the end.
+func insertAt(ids, inserted []int, position *int) []int {
+	index := len(ids)
+	if position != nil && *position < index {
+		index = *position
+	}
+	return slices.Insert(ids, index, inserted...)
+}
+
+// reorder stores each id's index in ids as its position using query, which
+// takes the position followed by the id.
+func reorder(execer utils.Execer, query string, ids []int) error {
+	stmt, err := execer.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for position, id := range ids {
+		if _, err := stmt.Exec(position, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func idsQueryRunner(execer utils.Execer, query string, args ...any) func() (*sql.Stmt, *sql.Rows, error) {
+	return func() (*sql.Stmt, *sql.Rows, error) {
+		stmt, err := execer.Prepare(query)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows, err := stmt.Query(args...)
+		return stmt, rows, err
+	}
+}
+
+func idsScanner(rows *sql.Rows) ([]int, error) {
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,132 @@
+package collections
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+// Roles a user can hold on a collection, from least to most privileged.
+// Viewers can read the collection and its bookmarks, editors can also change
+// them and file bookmarks in it, owners can also move or delete it and manage
+// its members. Collections belong to a workspace, and when acting on that
+// workspace its members are owners of all of its collections. Otherwise a
+// role comes from the collection being shared with the user. A role on a
+// collection also applies to everything nested in it.
+const (
+	ROLE_VIEWER = "viewer"
+	ROLE_EDITOR = "editor"
+	ROLE_OWNER  = "owner"
+)
+
+var roles = []string{ROLE_VIEWER, ROLE_EDITOR, ROLE_OWNER}
+
+const (
+	// ROLE_RANK turns the role of a collection_members row aliased as m into
+	// its position in roles, counting from 1.
+	ROLE_RANK = `CASE m.role WHEN 'viewer' THEN 1 WHEN 'editor' THEN 2 WHEN 'owner' THEN 3 END`
+	// COLLECTION_ROLE_RANK finds the highest rank a user holds on a collection
+	// by acting on its workspace or being a member of it or any of its
+	// ancestors, 0 for none.
+	COLLECTION_ROLE_RANK = `
+		WITH RECURSIVE ancestors(id, parent_id, workspace_id) AS (
+			SELECT id, parent_id, workspace_id FROM collections WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.parent_id, c.workspace_id FROM collections c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT COALESCE(MAX(rank), 0) FROM (
+			SELECT 3 AS rank FROM ancestors WHERE workspace_id = ?
+			UNION ALL
+			SELECT ` + ROLE_RANK + ` FROM collection_members m JOIN ancestors a ON m.collection_id = a.id WHERE m.user_id = ?
+		)`
+	// ACCESSIBLE_COLLECTIONS lists every collection a user can see from a
+	// workspace with the rank of their role on it, as the accessible table.
+	ACCESSIBLE_COLLECTIONS = `
+		WITH RECURSIVE accessible(id, rank) AS (
+			SELECT id, 3 FROM collections WHERE workspace_id = ?
+			UNION ALL
+			SELECT m.collection_id, ` + ROLE_RANK + ` FROM collection_members m WHERE m.user_id = ?
+			UNION ALL
+			SELECT c.id, a.rank FROM collections c JOIN accessible a ON c.parent_id = a.id
+		)`
+)
+
+// Allows tells whether holding granted is enough for something that needs required.
+func Allows(granted, required string) bool {
+	return slices.Index(roles, granted) >= slices.Index(roles, required)
+}
+
+// RoleOf returns the role a user acting on a workspace holds on a collection,
+// or an empty string when the collection is neither in that workspace nor
+// shared with them.
+func RoleOf(execer utils.Execer, collectionId, workspaceId, userId string) (string, error) {
+	stmt, err := execer.Prepare(COLLECTION_ROLE_RANK)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	var rank int
+	if err := stmt.QueryRow(collectionId, workspaceId, userId).Scan(&rank); err != nil {
+		return "", err
+	}
+	return roleOfRank(rank), nil
+}
+
+// Find loads a collection the user holds at least the required role on.
+// Collections they can't see at all are reported as not found.
+func Find(execer utils.Execer, id, workspaceId, userId, required string) (*Collection, error) {
+	role, err := RoleOf(execer, id, workspaceId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		return nil, utils.NotFound("Collection not found")
+	}
+	if !Allows(role, required) {
+		return nil, utils.Forbidden(fmt.Sprintf("This requires the %s role on the collection", required))
+	}
+
+	collection, err := utils.FindOne(
+		func() (*sql.Row, error) {
+			stmt, err := execer.Prepare(`
+				SELECT ` + collectionColumns + `
+				FROM collections c
+				WHERE c.id = ?`)
+			if err != nil {
+				return nil, err
+			}
+			return stmt.QueryRow(id), nil
+		},
+		collectionScanner,
+	)
+
+	var apiErr *utils.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == utils.NOT_FOUND {
+		return nil, utils.NotFound("Collection not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	collection.Role = role
+	return collection, nil
+}
+
+func roleOfRank(rank int) string {
+	if rank < 1 || rank > len(roles) {
+		return ""
+	}
+	return roles[rank-1]
+}
+
+func validateRole(role string) error {
+	if !slices.Contains(roles, role) {
+		return fmt.Errorf("Role should be one of: %s", strings.Join(roles, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+const DEFAULT_SMTP_PORT = "587"
+
+// Mailer sends plain text emails. SMTPMailer is the one configured from the
+// environment, others can be plugged in where a Mailer is expected.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// FromEnv returns the SMTPMailer configured by SMTP_HOST and SMTP_FROM, or
+// nil when emails aren't set up.
+func FromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		log.Printf("Ignoring SMTP_HOST since SMTP_FROM isn't set")
+		return nil
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = DEFAULT_SMTP_PORT
+	}
+	return SMTPMailer{
+		Addr:     net.JoinHostPort(host, port),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+}
+
+// SMTPMailer sends emails through an SMTP server, authenticating when
+// Username is set.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	// Line breaks would let the subject inject headers.
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		m.From, to, subject, strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(message))
+}
@@ -0,0 +1,152 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	FETCH_TIMEOUT = 10 * time.Second
+	// MAX_PAGE_SIZE caps how much of a page is read, and MAX_TEXT_LENGTH how
+	// much of its text is kept.
+	MAX_PAGE_SIZE   = 2 << 20
+	MAX_TEXT_LENGTH = 100_000
+	MAX_REDIRECTS   = 5
+)
+
+// ErrForbiddenAddress is returned for pages served from loopback, private,
+// shared and other special purpose addresses, which would let users probe
+// the network the API runs in.
+var ErrForbiddenAddress = errors.New("pages on internal addresses can't be fetched")
+
+var (
+	hiddenElements = regexp.MustCompile(`(?is)<(script|style|noscript|template|svg)\b.*?</(script|style|noscript|template|svg)\s*>`)
+	comments       = regexp.MustCompile(`(?s)<!--.*?-->`)
+	elements       = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+var client = &http.Client{
+	Timeout: FETCH_TIMEOUT,
+	Transport: &http.Transport{
+		Proxy: nil,
+		// Checking the address being dialed rather than the host name also
+		// covers redirects and DNS answers pointing inside.
+		DialContext: (&net.Dialer{
+			Timeout: FETCH_TIMEOUT,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if !isPublic(addrPort.Addr()) {
+					return ErrForbiddenAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   FETCH_TIMEOUT,
+		ResponseHeaderTimeout: FETCH_TIMEOUT,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= MAX_REDIRECTS {
+			return fmt.Errorf("stopped after %d redirects", MAX_REDIRECTS)
+		}
+		return nil
+	},
+}
+
+// FetchText downloads an HTML or plain text page and returns its readable
+// text.
+func FetchText(ctx context.Context, rawUrl string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Accept", "text/html, text/plain;q=0.9")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching %s: %s", rawUrl, response.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "text/plain" {
+		return "", fmt.Errorf("fetching %s: unsupported content type %q", rawUrl, mediaType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, MAX_PAGE_SIZE))
+	if err != nil {
+		return "", err
+	}
+
+	text := string(body)
+	if mediaType == "text/html" {
+		text = ExtractText(text)
+	}
+	if len(text) > MAX_TEXT_LENGTH {
+		text = strings.ToValidUTF8(text[:MAX_TEXT_LENGTH], "")
+	}
+	return text, nil
+}
+
+// ExtractText returns the text of an HTML document, leaving out markup,
+// scripts and styles.
+func ExtractText(document string) string {
+	document = hiddenElements.ReplaceAllString(document, " ")
+	document = comments.ReplaceAllString(document, " ")
+	document = elements.ReplaceAllString(document, " ")
+	return strings.Join(strings.Fields(html.UnescapeString(document)), " ")
+}
+
+// reservedPrefixes lists the special purpose ranges netip doesn't tell apart
+// from public ones. Some are shared inside a provider's network, like
+// carrier-grade NAT, and others translate or tunnel to IPv4 addresses that
+// may be internal.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // this network
+	netip.MustParsePrefix("100.64.0.0/10"),   // shared address space, carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relays
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, and the broadcast address
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local NAT64
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, Teredo among them
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+	netip.MustParsePrefix("3fff::/20"),       // documentation
+	netip.MustParsePrefix("fec0::/10"),       // site local
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
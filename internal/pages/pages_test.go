@@ -0,0 +1,73 @@
+package pages
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"100.128.0.1", true},
+		{"192.0.0.170", false},
+		{"198.18.0.1", false},
+		{"203.0.113.7", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:100.64.0.1", false},
+		{"64:ff9b::a00:1", false},
+		{"2001::1", false},
+		{"2001:db8::1", false},
+		{"2002:a00:1::", false},
+		{"fec0::1", false},
+	}
+
+	for _, test := range tests {
+		if got := isPublic(netip.MustParseAddr(test.addr)); got != test.want {
+			t.Errorf("isPublic(%s) = %t, want %t", test.addr, got, test.want)
+		}
+	}
+}
+
+func TestFetchTextRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the page was fetched")
+	}))
+	defer server.Close()
+
+	if _, err := FetchText(context.Background(), server.URL); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("expected ErrForbiddenAddress, got %v", err)
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	document := `<html><head><style>p { color: red }</style><script>alert("hi")</script></head>
+		<body><!-- hidden --><h1>Go &amp; SQLite</h1><p>Fast
+		and <b>simple</b>.</p><svg><text>icon</text></svg></body></html>`
+
+	if got, want := ExtractText(document), "Go & SQLite Fast and simple ."; got != want {
+		t.Errorf("ExtractText() = %q, want %q", got, want)
+	}
+}
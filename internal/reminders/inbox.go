@@ -0,0 +1,137 @@
+package reminders
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+const (
+	MARK_NOTIFICATION_READ = `UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP) WHERE id = ? AND user_id = ?`
+	DELETE_NOTIFICATION    = `DELETE FROM notifications WHERE id = ? AND user_id = ?`
+)
+
+// InboxItem is a notification left in the user's in-app inbox.
+type InboxItem struct {
+	Id         int        `json:"id"`
+	BookmarkId *int       `json:"bookmark_id"`
+	Message    string     `json:"message"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReadAt     *time.Time `json:"read_at"`
+}
+
+// GetInboxHandler lists the user's notifications, newest first, only the
+// unread ones with ?unread=true.
+func GetInboxHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		filter := ``
+		if unread, err := strconv.ParseBool(r.URL.Query().Get("unread")); err == nil && unread {
+			filter = `AND read_at IS NULL`
+		}
+
+		items, err := getInbox(db, userId, filter)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, items)
+	}
+}
+
+func MarkReadHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "notification")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, MARK_NOTIFICATION_READ, id, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Notification not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func DeleteNotificationHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "notification")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, DELETE_NOTIFICATION, id, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Notification not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getInbox(db *sql.DB, userId utils.UserId, filter string) ([]InboxItem, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := db.Prepare(`
+				SELECT id, bookmark_id, message, created_at, read_at
+				FROM notifications
+				WHERE user_id = ? ` + filter + `
+				ORDER BY created_at DESC, id DESC`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(userId)
+			return stmt, rows, err
+		},
+		inboxScanner,
+	)
+}
+
+func inboxScanner(rows *sql.Rows) ([]InboxItem, error) {
+	result := []InboxItem{}
+
+	for rows.Next() {
+		var item InboxItem
+		if err := rows.Scan(&item.Id, &item.BookmarkId, &item.Message, &item.CreatedAt, &item.ReadAt); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
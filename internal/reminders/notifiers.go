@@ -0,0 +1,137 @@
+package reminders
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/mail"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+const (
+	WEBHOOK_TIMEOUT = 10 * time.Second
+	// SIGNATURE_HEADER carries the hex HMAC-SHA256 of webhook bodies, keyed
+	// with REMINDER_WEBHOOK_SECRET, so receivers can check where they came from.
+	SIGNATURE_HEADER = "X-Signature-256"
+)
+
+const CREATE_NOTIFICATION = `INSERT INTO notifications (user_id, bookmark_id, message) VALUES(?, ?, ?)`
+
+// Notification tells a user that one of their reminders is due.
+type Notification struct {
+	Event         string    `json:"event"`
+	ReminderId    int       `json:"reminder_id"`
+	UserId        int       `json:"user_id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"-"`
+	BookmarkId    int       `json:"bookmark_id"`
+	BookmarkUrl   string    `json:"bookmark_url"`
+	BookmarkTitle string    `json:"bookmark_title,omitempty"`
+	RemindAt      time.Time `json:"remind_at"`
+	Recurrence    string    `json:"recurrence,omitempty"`
+}
+
+// Message is the text notifications show to the user.
+func (n Notification) Message() string {
+	if n.BookmarkTitle != "" {
+		return fmt.Sprintf("Reminder: %s (%s)", n.BookmarkTitle, n.BookmarkUrl)
+	}
+	return "Reminder: " + n.BookmarkUrl
+}
+
+// Notifier delivers notifications through one channel.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification) error
+}
+
+// Notifiers returns the notifiers configured through the environment. The
+// in-app inbox is always on, REMINDER_WEBHOOK_URL adds a webhook and
+// SMTP_HOST adds emails through mail.FromEnv.
+func Notifiers(db *sql.DB) []Notifier {
+	notifiers := []Notifier{InboxNotifier{db}}
+
+	if url := os.Getenv("REMINDER_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, WebhookNotifier{
+			Url:    url,
+			Secret: os.Getenv("REMINDER_WEBHOOK_SECRET"),
+			Client: &http.Client{Timeout: WEBHOOK_TIMEOUT},
+		})
+	}
+
+	if mailer := mail.FromEnv(); mailer != nil {
+		notifiers = append(notifiers, MailNotifier{Mailer: mailer})
+	}
+
+	return notifiers
+}
+
+// InboxNotifier leaves notifications in the user's in-app inbox.
+type InboxNotifier struct {
+	DB *sql.DB
+}
+
+func (n InboxNotifier) Notify(ctx context.Context, notification Notification) error {
+	_, err := utils.Exec(n.DB, CREATE_NOTIFICATION, notification.UserId, notification.BookmarkId, notification.Message())
+	return err
+}
+
+// WebhookNotifier posts notifications as JSON to Url, signed with Secret when
+// it's set.
+type WebhookNotifier struct {
+	Url    string
+	Secret string
+	Client *http.Client
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if n.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.Secret))
+		mac.Write(body)
+		request.Header.Set(SIGNATURE_HEADER, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := n.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// MailNotifier emails notifications to the address the user registered with.
+type MailNotifier struct {
+	Mailer mail.Mailer
+}
+
+func (n MailNotifier) Notify(ctx context.Context, notification Notification) error {
+	body := fmt.Sprintf("Hi %s,\n\nYou asked to be reminded about %s\n",
+		notification.Username, notification.BookmarkUrl)
+	if notification.BookmarkTitle != "" {
+		body = fmt.Sprintf("Hi %s,\n\nYou asked to be reminded about %s\n%s\n",
+			notification.Username, notification.BookmarkTitle, notification.BookmarkUrl)
+	}
+	return n.Mailer.Send(ctx, notification.Email, notification.Message(), body)
+}
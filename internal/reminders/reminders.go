@@ -0,0 +1,415 @@
+package reminders
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+// How often a reminder comes back once it fired or was dismissed. Reminders
+// without a recurrence go away once dismissed.
+const (
+	RECURRENCE_DAILY   = "daily"
+	RECURRENCE_WEEKLY  = "weekly"
+	RECURRENCE_MONTHLY = "monthly"
+	RECURRENCE_YEARLY  = "yearly"
+)
+
+var recurrences = []string{RECURRENCE_DAILY, RECURRENCE_WEEKLY, RECURRENCE_MONTHLY, RECURRENCE_YEARLY}
+
+var patchableFields = []string{"remind_at", "recurrence"}
+
+const (
+	CREATE_REMINDER = `INSERT INTO reminders (bookmark_id, user_id, remind_at, recurrence) VALUES(?, ?, ?, ?)`
+	UPDATE_REMINDER = `UPDATE reminders SET remind_at = ?, recurrence = ? WHERE id = ? AND user_id = ?`
+	DELETE_REMINDER = `DELETE FROM reminders WHERE id = ? AND user_id = ?`
+)
+
+// reminderAccess holds for reminders aliased as r on bookmarks aliased as b
+// while the reminder's user may still see the bookmark, as a member of its
+// workspace or through a role on the collection it's filed in or on one of
+// that collection's ancestors. Reminders of users who lost access are kept
+// but neither listed nor fired, until access comes back.
+const reminderAccess = `(
+	EXISTS (SELECT 1 FROM workspace_members w_m WHERE w_m.workspace_id = b.workspace_id AND w_m.user_id = r.user_id)
+	OR EXISTS (
+		WITH RECURSIVE ancestors(id, parent_id) AS (
+			SELECT c.id, c.parent_id FROM collection_bookmarks c_b JOIN collections c ON c_b.collection_id = c.id
+			WHERE c_b.bookmark_id = b.id
+			UNION ALL
+			SELECT c.id, c.parent_id FROM collections c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT 1 FROM collection_members m JOIN ancestors a ON m.collection_id = a.id WHERE m.user_id = r.user_id
+	))`
+
+// reminderColumns lists the columns scanned by remindersScanner, for
+// reminders aliased as r joined with their bookmark as b. Reminders on
+// bookmarks in the trash or out of the user's reach are left out by
+// reminderJoins.
+const (
+	reminderColumns = `r.id, r.bookmark_id, b.url, b.title, r.remind_at, r.recurrence, r.fired_at, r.created_at, r.updated_at`
+	reminderJoins   = `
+		FROM reminders r
+		JOIN bookmarks b ON r.bookmark_id = b.id AND b.deleted_at IS NULL
+		WHERE r.user_id = ? AND ` + reminderAccess
+)
+
+// Reminder brings a bookmark back to the user who set it at RemindAt. It's
+// due from then on until it's dismissed.
+type Reminder struct {
+	Id            int       `json:"id"`
+	BookmarkId    int       `json:"bookmark_id"`
+	BookmarkUrl   string    `json:"bookmark_url"`
+	BookmarkTitle string    `json:"bookmark_title,omitempty"`
+	RemindAt      time.Time `json:"remind_at"`
+	Recurrence    string    `json:"recurrence,omitempty"`
+	// FiredAt is when notifications about the reminder were last sent.
+	FiredAt   *time.Time `json:"fired_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type ReminderRequest struct {
+	RemindAt   *time.Time `json:"remind_at"`
+	Recurrence string     `json:"recurrence"`
+}
+
+// GetRemindersHandler lists the user's reminders, soonest first.
+func GetRemindersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		reminders, err := Get(db, userId, ``)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, reminders)
+	}
+}
+
+// GetDueRemindersHandler lists the user's reminders whose time has come and
+// that weren't dismissed yet.
+func GetDueRemindersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		reminders, err := Get(db, userId, `AND r.remind_at <= CURRENT_TIMESTAMP`)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, reminders)
+	}
+}
+
+// UpdateReminderHandler applies a JSON Merge Patch to a reminder, which can
+// reschedule or snooze it.
+func UpdateReminderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "reminder")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		patch, err := utils.DecodeRequestBody[map[string]json.RawMessage](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		existing, err := Find(db, id, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, errs := applyMergePatch(existing, *patch)
+		if _, ok := (*patch)["remind_at"]; ok {
+			errs.Merge(request.Validate())
+		} else {
+			errs.Merge(request.validateRecurrence())
+		}
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if _, err := utils.Exec(db, UPDATE_REMINDER, append(request.values(), id, userId)...); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeReminder(w, r, db, id, userId, http.StatusOK)
+	}
+}
+
+func DeleteReminderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "reminder")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, DELETE_REMINDER, id, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Reminder not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DismissReminderHandler marks a due reminder as dealt with. Recurring
+// reminders move on to their next occurrence after now and come back in the
+// response, others are deleted.
+func DismissReminderHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "reminder")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		reminder, err := Find(db, id, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if reminder.RemindAt.After(time.Now()) {
+			utils.WriteError(w, r, utils.Conflict("The reminder isn't due yet"))
+			return
+		}
+
+		if reminder.Recurrence == "" {
+			if _, err := utils.Exec(db, DELETE_REMINDER, id, userId); err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next := NextOccurrence(reminder.RemindAt, reminder.Recurrence, time.Now())
+		request := ReminderRequest{RemindAt: &next, Recurrence: reminder.Recurrence}
+		if _, err := utils.Exec(db, UPDATE_REMINDER, append(request.values(), id, userId)...); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeReminder(w, r, db, id, userId, http.StatusOK)
+	}
+}
+
+// NextOccurrence returns the first occurrence of a recurring reminder
+// scheduled at start that comes after now. Occurrences are counted from start
+// so that monthly reminders set on the 31st don't drift.
+func NextOccurrence(start time.Time, recurrence string, now time.Time) time.Time {
+	years, months, days := 0, 0, 0
+	switch recurrence {
+	case RECURRENCE_DAILY:
+		days = 1
+	case RECURRENCE_WEEKLY:
+		days = 7
+	case RECURRENCE_MONTHLY:
+		months = 1
+	case RECURRENCE_YEARLY:
+		years = 1
+	default:
+		return start
+	}
+
+	next := start
+	for n := 1; !next.After(now); n++ {
+		next = start.AddDate(years*n, months*n, days*n)
+	}
+	return next
+}
+
+// Validate normalizes the request in place and returns every rule it breaks.
+func (r *ReminderRequest) Validate() utils.FieldErrors {
+	errs := r.validateRecurrence()
+
+	if r.RemindAt == nil {
+		errs.Add("remind_at", "Reminder time is required")
+	} else if !r.RemindAt.After(time.Now()) {
+		errs.Add("remind_at", "Reminder time should be in the future")
+	}
+
+	return errs
+}
+
+func (r *ReminderRequest) validateRecurrence() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	r.Recurrence = strings.ToLower(strings.TrimSpace(r.Recurrence))
+	if r.Recurrence != "" && !slices.Contains(recurrences, r.Recurrence) {
+		errs.Add("recurrence", fmt.Sprintf("Recurrence should be one of: %s", strings.Join(recurrences, ", ")))
+	}
+
+	return errs
+}
+
+// values returns the reminder time and recurrence to store. Times are stored
+// the way SQLite writes CURRENT_TIMESTAMP so the two compare.
+func (r *ReminderRequest) values() []any {
+	var recurrence any
+	if r.Recurrence != "" {
+		recurrence = r.Recurrence
+	}
+	return []any{r.RemindAt.UTC().Format(time.DateTime), recurrence}
+}
+
+// Create sets a reminder on a bookmark for the user. Callers check the user
+// may see the bookmark.
+func Create(execer utils.Execer, bookmarkId string, userId utils.UserId, request *ReminderRequest) (int64, error) {
+	result, err := utils.Exec(execer, CREATE_REMINDER, append([]any{bookmarkId, userId}, request.values()...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7386) document to an
+// existing reminder. Absent members keep their current value and null clears
+// the recurrence.
+func applyMergePatch(existing *Reminder, patch map[string]json.RawMessage) (*ReminderRequest, utils.FieldErrors) {
+	errs := utils.FieldErrors{}
+	remindAt := existing.RemindAt
+	result := &ReminderRequest{RemindAt: &remindAt, Recurrence: existing.Recurrence}
+
+	for field := range patch {
+		if !slices.Contains(patchableFields, field) {
+			errs.Add(field, "Unknown or read-only field")
+		}
+	}
+
+	if value, ok := patch["remind_at"]; ok {
+		result.RemindAt = nil
+		if string(value) != "null" {
+			if err := json.Unmarshal(value, &result.RemindAt); err != nil {
+				errs.Add("remind_at", "Should be an RFC 3339 date and time")
+			}
+		}
+	}
+
+	if value, ok := patch["recurrence"]; ok {
+		result.Recurrence = ""
+		if string(value) != "null" {
+			if err := json.Unmarshal(value, &result.Recurrence); err != nil {
+				errs.Add("recurrence", "Should be a string or null")
+			}
+		}
+	}
+
+	return result, errs
+}
+
+func writeReminder(w http.ResponseWriter, r *http.Request, db *sql.DB, id string, userId utils.UserId, status int) {
+	reminder, err := Find(db, id, userId)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, status, reminder)
+}
+
+// Find loads one of the user's reminders.
+func Find(execer utils.Execer, id string, userId utils.UserId) (*Reminder, error) {
+	reminders, err := Get(execer, userId, `AND r.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(reminders) == 0 {
+		return nil, utils.NotFound("Reminder not found")
+	}
+	return &reminders[0], nil
+}
+
+// Get lists the user's reminders matching filter, a condition appended to
+// the WHERE clause of reminderJoins, soonest first.
+func Get(execer utils.Execer, userId utils.UserId, filter string, args ...any) ([]Reminder, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := execer.Prepare(`
+				SELECT ` + reminderColumns + reminderJoins + ` ` + filter + `
+				ORDER BY r.remind_at, r.id`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(append([]any{userId}, args...)...)
+			return stmt, rows, err
+		},
+		remindersScanner,
+	)
+}
+
+func remindersScanner(rows *sql.Rows) ([]Reminder, error) {
+	result := []Reminder{}
+
+	for rows.Next() {
+		var reminder Reminder
+		var title, recurrence sql.NullString
+		err := rows.Scan(
+			&reminder.Id,
+			&reminder.BookmarkId,
+			&reminder.BookmarkUrl,
+			&title,
+			&reminder.RemindAt,
+			&recurrence,
+			&reminder.FiredAt,
+			&reminder.CreatedAt,
+			&reminder.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reminder.BookmarkTitle = title.String
+		reminder.Recurrence = recurrence.String
+		result = append(result, reminder)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,132 @@
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+const (
+	SCHEDULER_INTERVAL = time.Minute
+	// FIRE_BATCH_SIZE caps how many reminders are fired per run, the rest
+	// wait for the next one.
+	FIRE_BATCH_SIZE = 100
+)
+
+const (
+	// UNFIRED_REMINDERS lists due reminders nobody was notified about since
+	// they were last scheduled, as long as their user may still see the
+	// bookmark.
+	UNFIRED_REMINDERS = `
+		SELECT r.id, r.user_id, u.username, u.email, r.bookmark_id, b.url, b.title, r.remind_at, r.recurrence
+		FROM reminders r
+		JOIN bookmarks b ON r.bookmark_id = b.id AND b.deleted_at IS NULL
+		JOIN users u ON r.user_id = u.id
+		WHERE r.remind_at <= CURRENT_TIMESTAMP AND (r.fired_at IS NULL OR r.fired_at < r.remind_at)
+			AND ` + reminderAccess + `
+		ORDER BY r.remind_at, r.id
+		LIMIT ?`
+	MARK_FIRED = `UPDATE reminders SET fired_at = CURRENT_TIMESTAMP WHERE id = ?`
+	// RESCHEDULE_FIRED marks a recurring reminder as fired and moves it on
+	// to its next occurrence.
+	RESCHEDULE_FIRED = `UPDATE reminders SET fired_at = CURRENT_TIMESTAMP, remind_at = ? WHERE id = ?`
+)
+
+// Fire notifies users about their reminders that came due, through every
+// notifier, and returns how many reminders it fired. Each reminder is fired
+// once per occurrence even when some notifiers fail, their errors are only
+// logged. Recurring reminders then move on to their next occurrence after
+// now, so they keep firing whether or not they're dismissed.
+func Fire(ctx context.Context, db *sql.DB, notifiers []Notifier) (int, error) {
+	notifications, err := utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := db.Prepare(UNFIRED_REMINDERS)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(FIRE_BATCH_SIZE)
+			return stmt, rows, err
+		},
+		notificationsScanner,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, notification := range notifications {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+
+		for _, notifier := range notifiers {
+			if err := notifier.Notify(ctx, notification); err != nil {
+				log.Printf("Notifying user %d about reminder %d through %T failed: %v",
+					notification.UserId, notification.ReminderId, notifier, err)
+			}
+		}
+
+		if notification.Recurrence != "" {
+			next := NextOccurrence(notification.RemindAt, notification.Recurrence, time.Now())
+			_, err = utils.Exec(db, RESCHEDULE_FIRED, next.UTC().Format(time.DateTime), notification.ReminderId)
+		} else {
+			_, err = utils.Exec(db, MARK_FIRED, notification.ReminderId)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(notifications), nil
+}
+
+// StartScheduler runs Fire right away and then every SCHEDULER_INTERVAL until
+// ctx is done.
+func StartScheduler(ctx context.Context, db *sql.DB, notifiers []Notifier) {
+	ticker := time.NewTicker(SCHEDULER_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if fired, err := Fire(ctx, db, notifiers); err != nil && ctx.Err() == nil {
+			log.Printf("Firing reminders failed: %v", err)
+		} else if fired > 0 {
+			log.Printf("Fired %d reminders", fired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func notificationsScanner(rows *sql.Rows) ([]Notification, error) {
+	result := []Notification{}
+
+	for rows.Next() {
+		notification := Notification{Event: "reminder.due"}
+		var title, recurrence sql.NullString
+		err := rows.Scan(
+			&notification.ReminderId,
+			&notification.UserId,
+			&notification.Username,
+			&notification.Email,
+			&notification.BookmarkId,
+			&notification.BookmarkUrl,
+			&title,
+			&notification.RemindAt,
+			&recurrence,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notification.BookmarkTitle = title.String
+		notification.Recurrence = recurrence.String
+		result = append(result, notification)
+	}
+
+	return result, rows.Err()
+}
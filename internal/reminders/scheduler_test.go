@@ -0,0 +1,121 @@
+package reminders
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+type recordingNotifier struct {
+	reminderIds []int
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification Notification) error {
+	n.reminderIds = append(n.reminderIds, notification.ReminderId)
+	return nil
+}
+
+func TestNextOccurrence(t *testing.T) {
+	date := func(value string) time.Time {
+		parsed, err := time.Parse(time.DateTime, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name       string
+		start      string
+		recurrence string
+		now        string
+		want       string
+	}{
+		{"daily skips missed days", "2026-01-01 09:00:00", RECURRENCE_DAILY, "2026-01-03 12:00:00", "2026-01-04 09:00:00"},
+		{"an occurrence at now is past", "2026-01-01 09:00:00", RECURRENCE_DAILY, "2026-01-03 09:00:00", "2026-01-04 09:00:00"},
+		{"weekly", "2026-01-01 09:00:00", RECURRENCE_WEEKLY, "2026-01-02 09:00:00", "2026-01-08 09:00:00"},
+		{"monthly counts from the start", "2026-01-31 09:00:00", RECURRENCE_MONTHLY, "2026-03-05 09:00:00", "2026-03-31 09:00:00"},
+		{"yearly", "2024-02-29 09:00:00", RECURRENCE_YEARLY, "2024-03-01 09:00:00", "2025-03-01 09:00:00"},
+		{"without recurrence", "2026-01-01 09:00:00", "", "2026-02-01 09:00:00", "2026-01-01 09:00:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NextOccurrence(date(test.start), test.recurrence, date(test.now))
+			if !got.Equal(date(test.want)) {
+				t.Errorf("expected %s, got %s", test.want, got.Format(time.DateTime))
+			}
+		})
+	}
+}
+
+func TestFire(t *testing.T) {
+	t.Chdir(t.TempDir())
+	db, err := utils.InitDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	start := now.Add(-49 * time.Hour)
+	past := start.Format(time.DateTime)
+
+	// Alice (1) can see her own bookmark (3) and the one bob (2) filed under
+	// the collection he shared with her (2), but not bob's other bookmark (1).
+	for _, query := range []string{
+		`INSERT INTO users (username, email, password_hash) VALUES ('alice', 'alice@example.com', ''), ('bob', 'bob@example.com', '')`,
+		`INSERT INTO workspaces (name, personal, created_by) VALUES ('alice', TRUE, 1), ('bob', TRUE, 2)`,
+		`INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (1, 1, 'owner'), (2, 2, 'owner')`,
+		`INSERT INTO bookmarks (user_id, workspace_id, url) VALUES (2, 2, 'https://example.com/private'), (2, 2, 'https://example.com/shared'), (1, 1, 'https://example.com/own')`,
+		`INSERT INTO collections (user_id, workspace_id, parent_id, name) VALUES (2, 2, NULL, 'Shared'), (2, 2, 1, 'Nested')`,
+		`INSERT INTO collection_bookmarks (bookmark_id, collection_id) VALUES (2, 2)`,
+		`INSERT INTO collection_members (collection_id, user_id, role) VALUES (1, 1, 'viewer')`,
+		`INSERT INTO reminders (bookmark_id, user_id, remind_at, recurrence) VALUES
+			(1, 1, '` + past + `', NULL), (2, 1, '` + past + `', NULL), (3, 1, '` + past + `', 'daily')`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifier := &recordingNotifier{}
+	fired, err := Fire(context.Background(), db, []Notifier{notifier})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fired != 2 || !slices.Equal(notifier.reminderIds, []int{2, 3}) {
+		t.Fatalf("expected reminders 2 and 3 to fire, got %d: %v", fired, notifier.reminderIds)
+	}
+
+	var remindAt time.Time
+	if err := db.QueryRow(`SELECT remind_at FROM reminders WHERE id = 3`).Scan(&remindAt); err != nil {
+		t.Fatal(err)
+	}
+	if want := start.AddDate(0, 0, 3); !remindAt.Equal(want) {
+		t.Errorf("expected the daily reminder to move on to %s, got %s", want, remindAt)
+	}
+
+	if fired, err := Fire(context.Background(), db, []Notifier{notifier}); err != nil || fired != 0 {
+		t.Errorf("expected nothing left to fire, got %d, %v", fired, err)
+	}
+
+	// Losing access to the collection hides its reminders.
+	if _, err := db.Exec(`DELETE FROM collection_members`); err != nil {
+		t.Fatal(err)
+	}
+	reminders, err := Get(db, "1", ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, reminder := range reminders {
+		ids = append(ids, reminder.Id)
+	}
+	if !slices.Equal(ids, []int{3}) {
+		t.Errorf("expected only reminder 3 to be listed, got %v", ids)
+	}
+}
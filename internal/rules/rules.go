@@ -0,0 +1,480 @@
+package rules
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/tags"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const (
+	MAX_DOMAIN_LENGTH      = 253
+	MAX_URL_PATTERN_LENGTH = 500
+	MAX_TITLE_KEYWORDS     = 20
+	MAX_KEYWORD_LENGTH     = 100
+)
+
+var patchableFields = []string{"domain", "url_pattern", "title_keywords", "tags", "enabled"}
+
+const (
+	CREATE_RULE = `INSERT INTO tag_rules (user_id, workspace_id, domain, url_pattern, title_keywords, tags, enabled) VALUES(?, ?, ?, ?, ?, ?, ?)`
+	UPDATE_RULE = `UPDATE tag_rules SET domain = ?, url_pattern = ?, title_keywords = ?, tags = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ? AND workspace_id = ?`
+	DELETE_RULE = `DELETE FROM tag_rules WHERE id = ? AND user_id = ? AND workspace_id = ?`
+)
+
+// ruleColumns lists the columns scanned by rulesScanner.
+const ruleColumns = `id, COALESCE(domain, ''), COALESCE(url_pattern, ''), title_keywords, tags, enabled, created_at, updated_at`
+
+// Rule tags the bookmarks its owner saves in a workspace when they match it.
+// A bookmark matches when it matches every condition the rule has: its host
+// is the domain or one of its subdomains, its URL matches the pattern, and
+// its title contains one of the keywords as whole words, ignoring case.
+type Rule struct {
+	Id            int       `json:"id"`
+	Domain        string    `json:"domain,omitempty"`
+	UrlPattern    string    `json:"url_pattern,omitempty"`
+	TitleKeywords []string  `json:"title_keywords"`
+	Tags          []string  `json:"tags"`
+	Enabled       bool      `json:"enabled"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	pattern *regexp.Regexp
+}
+
+type RuleRequest struct {
+	Domain        string   `json:"domain"`
+	UrlPattern    string   `json:"url_pattern"`
+	TitleKeywords []string `json:"title_keywords"`
+	Tags          []string `json:"tags"`
+	// Enabled defaults to true, disabled rules only apply when asked to.
+	Enabled *bool `json:"enabled"`
+}
+
+// GetRulesHandler lists the user's rules in the workspace, oldest first.
+func GetRulesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		rules, err := Get(db, workspaceId, userId, ``)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, rules)
+	}
+}
+
+func CreateRuleHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[RuleRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if errs := request.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		result, err := utils.Exec(db, CREATE_RULE, append([]any{userId, workspaceId}, request.values()...)...)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeRule(w, r, db, strconv.FormatInt(id, 10), workspaceId, userId, http.StatusCreated)
+	}
+}
+
+// UpdateRuleHandler applies a JSON Merge Patch to a rule. Changing a rule
+// doesn't touch the bookmarks it already tagged.
+func UpdateRuleHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "rule")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		patch, err := utils.DecodeRequestBody[map[string]json.RawMessage](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		existing, err := Find(db, id, workspaceId, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, errs := applyMergePatch(existing, *patch)
+		errs.Merge(request.validate())
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if _, err := utils.Exec(db, UPDATE_RULE, append(request.values(), id, userId, workspaceId)...); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		writeRule(w, r, db, id, workspaceId, userId, http.StatusOK)
+	}
+}
+
+func DeleteRuleHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "rule")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, DELETE_RULE, id, userId, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Rule not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Matches tells whether a bookmark with the given URL and title matches the
+// rule.
+func (r *Rule) Matches(rawUrl, title string) bool {
+	if r.Domain != "" {
+		parsed, err := url.Parse(rawUrl)
+		if err != nil {
+			return false
+		}
+		host := strings.ToLower(parsed.Hostname())
+		if host != r.Domain && !strings.HasSuffix(host, "."+r.Domain) {
+			return false
+		}
+	}
+
+	if r.UrlPattern != "" && (r.pattern == nil || !r.pattern.MatchString(rawUrl)) {
+		return false
+	}
+
+	if len(r.TitleKeywords) > 0 {
+		words := normalizeWords(title)
+		if !slices.ContainsFunc(r.TitleKeywords, func(keyword string) bool {
+			return strings.Contains(words, normalizeWords(keyword))
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchingTags returns the tags that the user's enabled rules in the
+// workspace give a bookmark with the given URL and title.
+func MatchingTags(execer utils.Execer, workspaceId string, userId utils.UserId, rawUrl, title string) ([]string, error) {
+	rules, err := Get(execer, workspaceId, userId, `AND enabled`)
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []string{}
+	for _, rule := range rules {
+		if rule.Matches(rawUrl, title) {
+			matching = append(matching, rule.Tags...)
+		}
+	}
+	return tags.NormalizeNames(matching), nil
+}
+
+// validate normalizes the request in place and returns every rule it breaks.
+func (r *RuleRequest) validate() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	r.Domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(r.Domain)), ".")
+	if r.Domain != "" {
+		if len(r.Domain) > MAX_DOMAIN_LENGTH || strings.ContainsAny(r.Domain, "/:?# ") || strings.HasPrefix(r.Domain, ".") {
+			errs.Add("domain", "Domain should be a host name such as github.com")
+		}
+	}
+
+	r.UrlPattern = strings.TrimSpace(r.UrlPattern)
+	if utf8.RuneCountInString(r.UrlPattern) > MAX_URL_PATTERN_LENGTH {
+		errs.Add("url_pattern", fmt.Sprintf("URL pattern should be no longer than %d characters", MAX_URL_PATTERN_LENGTH))
+	} else if _, err := regexp.Compile(r.UrlPattern); err != nil {
+		errs.Add("url_pattern", "URL pattern should be a valid regular expression: "+err.Error())
+	}
+
+	keywords := []string{}
+	for _, keyword := range r.TitleKeywords {
+		keyword = strings.Join(strings.Fields(keyword), " ")
+		if strings.TrimSpace(normalizeWords(keyword)) == "" || slices.Contains(keywords, keyword) {
+			continue
+		}
+		if utf8.RuneCountInString(keyword) > MAX_KEYWORD_LENGTH {
+			errs.Add("title_keywords", fmt.Sprintf("Keyword %q should be no longer than %d characters", keyword, MAX_KEYWORD_LENGTH))
+		}
+		keywords = append(keywords, keyword)
+	}
+	r.TitleKeywords = keywords
+	if len(r.TitleKeywords) > MAX_TITLE_KEYWORDS {
+		errs.Add("title_keywords", fmt.Sprintf("At most %d keywords are allowed", MAX_TITLE_KEYWORDS))
+	}
+
+	if r.Domain == "" && r.UrlPattern == "" && len(r.TitleKeywords) == 0 {
+		errs.Add("domain", "A domain, a URL pattern or title keywords are required")
+	}
+
+	r.Tags = tags.NormalizeNames(r.Tags)
+	if len(r.Tags) == 0 {
+		errs.Add("tags", "At least one tag is required")
+	}
+	errs.Merge(tags.ValidateNames("tags", r.Tags))
+
+	if r.Enabled == nil {
+		enabled := true
+		r.Enabled = &enabled
+	}
+
+	return errs
+}
+
+// values returns the domain, URL pattern, keywords, tags and enabled flag to
+// store, with NULL for the conditions left empty.
+func (r *RuleRequest) values() []any {
+	var domain, urlPattern any
+	if r.Domain != "" {
+		domain = r.Domain
+	}
+	if r.UrlPattern != "" {
+		urlPattern = r.UrlPattern
+	}
+	keywords, _ := json.Marshal(r.TitleKeywords)
+	tagNames, _ := json.Marshal(r.Tags)
+	return []any{domain, urlPattern, string(keywords), string(tagNames), *r.Enabled}
+}
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7386) document to an
+// existing rule. Absent members keep their current value and null clears it.
+func applyMergePatch(existing *Rule, patch map[string]json.RawMessage) (*RuleRequest, utils.FieldErrors) {
+	errs := utils.FieldErrors{}
+	enabled := existing.Enabled
+	result := &RuleRequest{
+		Domain:        existing.Domain,
+		UrlPattern:    existing.UrlPattern,
+		TitleKeywords: existing.TitleKeywords,
+		Tags:          existing.Tags,
+		Enabled:       &enabled,
+	}
+
+	for field := range patch {
+		if !slices.Contains(patchableFields, field) {
+			errs.Add(field, "Unknown or read-only field")
+		}
+	}
+
+	stringFields := map[string]*string{
+		"domain":      &result.Domain,
+		"url_pattern": &result.UrlPattern,
+	}
+	for field, target := range stringFields {
+		value, ok := patch[field]
+		if !ok {
+			continue
+		}
+		*target = ""
+		if string(value) != "null" {
+			if err := json.Unmarshal(value, target); err != nil {
+				errs.Add(field, "Should be a string or null")
+			}
+		}
+	}
+
+	listFields := map[string]*[]string{
+		"title_keywords": &result.TitleKeywords,
+		"tags":           &result.Tags,
+	}
+	for field, target := range listFields {
+		value, ok := patch[field]
+		if !ok {
+			continue
+		}
+		*target = []string{}
+		if string(value) != "null" {
+			if err := json.Unmarshal(value, target); err != nil {
+				errs.Add(field, "Should be a list of strings or null")
+			}
+		}
+	}
+
+	if value, ok := patch["enabled"]; ok {
+		enabled = false
+		if string(value) != "null" {
+			if err := json.Unmarshal(value, &enabled); err != nil {
+				errs.Add("enabled", "Should be a boolean or null")
+			}
+		}
+	}
+
+	return result, errs
+}
+
+// normalizeWords lowercases text and keeps only its words, separated and
+// surrounded by single spaces, so that looking for a normalized keyword in
+// it only matches whole words.
+func normalizeWords(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return " " + strings.Join(words, " ") + " "
+}
+
+func writeRule(w http.ResponseWriter, r *http.Request, db *sql.DB, id, workspaceId string, userId utils.UserId, status int) {
+	rule, err := Find(db, id, workspaceId, userId)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, status, rule)
+}
+
+// Find loads one of the user's rules in the workspace.
+func Find(execer utils.Execer, id, workspaceId string, userId utils.UserId) (*Rule, error) {
+	rules, err := Get(execer, workspaceId, userId, `AND id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, utils.NotFound("Rule not found")
+	}
+	return &rules[0], nil
+}
+
+// Get lists the user's rules in the workspace matching filter, a condition
+// appended to the WHERE clause, oldest first.
+func Get(execer utils.Execer, workspaceId string, userId utils.UserId, filter string, args ...any) ([]Rule, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := execer.Prepare(`
+				SELECT ` + ruleColumns + `
+				FROM tag_rules
+				WHERE workspace_id = ? AND user_id = ? ` + filter + `
+				ORDER BY id`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(append([]any{workspaceId, userId}, args...)...)
+			return stmt, rows, err
+		},
+		rulesScanner,
+	)
+}
+
+func rulesScanner(rows *sql.Rows) ([]Rule, error) {
+	result := []Rule{}
+
+	for rows.Next() {
+		var rule Rule
+		var keywords, tagNames string
+		err := rows.Scan(
+			&rule.Id,
+			&rule.Domain,
+			&rule.UrlPattern,
+			&keywords,
+			&tagNames,
+			&rule.Enabled,
+			&rule.CreatedAt,
+			&rule.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(keywords), &rule.TitleKeywords); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(tagNames), &rule.Tags); err != nil {
+			return nil, err
+		}
+		// Patterns were checked when the rule was saved.
+		if rule.UrlPattern != "" {
+			rule.pattern, _ = regexp.Compile(rule.UrlPattern)
+		}
+		result = append(result, rule)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,372 @@
+package shares
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PASSWORD_HEADER carries the password of a protected share link for API
+// clients. The HTML page posts it as the password form field instead.
+const PASSWORD_HEADER = "X-Share-Password"
+
+const (
+	SHARE_TYPE_BOOKMARK   = "bookmark"
+	SHARE_TYPE_COLLECTION = "collection"
+)
+
+const (
+	// FIND_SHARE loads a share link that can still be used.
+	FIND_SHARE = `
+		SELECT id, bookmark_id, collection_id, password_hash, expires_at
+		FROM share_links
+		WHERE token = ? AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
+	COUNT_VIEW = `UPDATE share_links SET view_count = view_count + 1, last_viewed_at = CURRENT_TIMESTAMP WHERE id = ?`
+	// SHARED_SUBTREE lists a collection and everything nested in it as the
+	// subtree table.
+	SHARED_SUBTREE = `
+		WITH RECURSIVE subtree(id) AS (
+			SELECT ?
+			UNION ALL
+			SELECT c.id FROM collections c JOIN subtree s ON c.parent_id = s.id
+		)`
+)
+
+// publicBookmarkColumns lists the columns scanned by PublicBookmark.scanFields,
+// for bookmarks aliased as b. Notes, reading status and the like stay private.
+const publicBookmarkColumns = `b.url, b.title, b.description,
+	(SELECT json_group_array(name) FROM (
+		SELECT t.name FROM bookmark_tags b_t JOIN tags t ON b_t.tag_id = t.id
+		WHERE b_t.bookmark_id = b.id AND t.deleted_at IS NULL
+		ORDER BY t.name
+	)),
+	b.created_at`
+
+// PublicBookmark is what a share link shows of a bookmark.
+type PublicBookmark struct {
+	Url         string    `json:"url"`
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Tags        []string  `json:"tags"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PublicCollection is what a share link shows of a collection: the bookmarks
+// filed in it and its sub-collections, each in their manual order.
+type PublicCollection struct {
+	Name        string              `json:"name"`
+	Bookmarks   []PublicBookmark    `json:"bookmarks"`
+	Collections []*PublicCollection `json:"collections"`
+}
+
+// SharedItem is served to whoever opens a share link.
+type SharedItem struct {
+	Type       string            `json:"type"`
+	Bookmark   *PublicBookmark   `json:"bookmark,omitempty"`
+	Collection *PublicCollection `json:"collection,omitempty"`
+	ExpiresAt  *time.Time        `json:"expires_at,omitempty"`
+}
+
+type shareLink struct {
+	id           int
+	bookmarkId   sql.NullInt64
+	collectionId sql.NullInt64
+	passwordHash sql.NullString
+	expiresAt    *time.Time
+}
+
+// SharedItemHandler serves the bookmark or collection behind a share link to
+// anyone, as JSON or, for browsers, as an HTML page. Password protected links
+// take the password from the X-Share-Password header or a posted form. Every
+// successful view is counted.
+func SharedItemHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Links may be protected and views are counted, so nothing is cached,
+		// and the token isn't leaked to the sites the page links to.
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("X-Robots-Tag", "noindex")
+
+		share, err := findShare(db, r.PathValue("token"))
+		if err != nil {
+			writeShareError(w, r, err)
+			return
+		}
+
+		if err := checkPassword(r, share); err != nil {
+			writeShareError(w, r, err)
+			return
+		}
+
+		item, err := sharedItem(db, share)
+		if err != nil {
+			writeShareError(w, r, err)
+			return
+		}
+
+		if r.Method != http.MethodHead {
+			if _, err := utils.Exec(db, COUNT_VIEW, share.id); err != nil {
+				writeShareError(w, r, err)
+				return
+			}
+		}
+
+		if wantsHTML(r) {
+			writePage(w, http.StatusOK, sharePage{Item: item})
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, item)
+	}
+}
+
+func findShare(execer utils.Execer, token string) (*shareLink, error) {
+	stmt, err := execer.Prepare(FIND_SHARE)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var share shareLink
+	err = stmt.QueryRow(token).Scan(&share.id, &share.bookmarkId, &share.collectionId, &share.passwordHash, &share.expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, utils.NotFound("Share link not found")
+	}
+	return &share, err
+}
+
+func checkPassword(r *http.Request, share *shareLink) error {
+	if !share.passwordHash.Valid {
+		return nil
+	}
+
+	password := r.Header.Get(PASSWORD_HEADER)
+	if password == "" && r.Method == http.MethodPost {
+		password = r.PostFormValue("password")
+	}
+	if password == "" {
+		return utils.Unauthorized("This share link needs a password")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(share.passwordHash.String), []byte(password)) != nil {
+		return utils.Unauthorized("Wrong password")
+	}
+	return nil
+}
+
+// sharedItem loads what a share link points to. Bookmarks in the trash can't
+// be seen through their link until they're restored.
+func sharedItem(db *sql.DB, share *shareLink) (*SharedItem, error) {
+	item := &SharedItem{ExpiresAt: share.expiresAt}
+
+	if share.bookmarkId.Valid {
+		bookmarks, err := getPublicBookmarks(db, `
+			SELECT NULL, `+publicBookmarkColumns+`
+			FROM bookmarks b
+			WHERE b.id = ? AND b.deleted_at IS NULL`,
+			share.bookmarkId.Int64)
+		if err != nil {
+			return nil, err
+		}
+		if len(bookmarks) == 0 {
+			return nil, utils.NotFound("Share link not found")
+		}
+		item.Type = SHARE_TYPE_BOOKMARK
+		item.Bookmark = &bookmarks[0].PublicBookmark
+		return item, nil
+	}
+
+	collection, err := getPublicCollection(db, share.collectionId.Int64)
+	if err != nil {
+		return nil, err
+	}
+	item.Type = SHARE_TYPE_COLLECTION
+	item.Collection = collection
+	return item, nil
+}
+
+// getPublicCollection loads a collection with everything nested in it.
+func getPublicCollection(db *sql.DB, id int64) (*PublicCollection, error) {
+	stmt, err := db.Prepare(SHARED_SUBTREE + `
+		SELECT c.id, c.parent_id, c.name
+		FROM collections c JOIN subtree s ON c.id = s.id
+		ORDER BY c.position, c.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type node struct {
+		parentId sql.NullInt64
+		*PublicCollection
+	}
+	nodes := map[int64]node{}
+	var order []int64
+	for rows.Next() {
+		var nodeId int64
+		n := node{PublicCollection: &PublicCollection{Bookmarks: []PublicBookmark{}, Collections: []*PublicCollection{}}}
+		if err := rows.Scan(&nodeId, &n.parentId, &n.Name); err != nil {
+			return nil, err
+		}
+		nodes[nodeId] = n
+		order = append(order, nodeId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	root, ok := nodes[id]
+	if !ok {
+		return nil, utils.NotFound("Share link not found")
+	}
+	for _, nodeId := range order {
+		if n := nodes[nodeId]; nodeId != id {
+			parent := nodes[n.parentId.Int64]
+			parent.Collections = append(parent.Collections, n.PublicCollection)
+		}
+	}
+
+	bookmarks, err := getPublicBookmarks(db, SHARED_SUBTREE+`
+		SELECT c_b.collection_id, `+publicBookmarkColumns+`
+		FROM collection_bookmarks c_b
+		JOIN subtree s ON c_b.collection_id = s.id
+		JOIN bookmarks b ON c_b.bookmark_id = b.id AND b.deleted_at IS NULL
+		ORDER BY c_b.position, b.id`,
+		id)
+	if err != nil {
+		return nil, err
+	}
+	for _, bookmark := range bookmarks {
+		collection := nodes[bookmark.collectionId.Int64]
+		collection.Bookmarks = append(collection.Bookmarks, bookmark.PublicBookmark)
+	}
+
+	return root.PublicCollection, nil
+}
+
+type filedBookmark struct {
+	collectionId sql.NullInt64
+	PublicBookmark
+}
+
+func getPublicBookmarks(db *sql.DB, query string, args ...any) ([]filedBookmark, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := db.Prepare(query)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(args...)
+			return stmt, rows, err
+		},
+		publicBookmarksScanner,
+	)
+}
+
+func publicBookmarksScanner(rows *sql.Rows) ([]filedBookmark, error) {
+	result := []filedBookmark{}
+
+	for rows.Next() {
+		var bookmark filedBookmark
+		var title, description sql.NullString
+		var tags string
+		err := rows.Scan(&bookmark.collectionId, &bookmark.Url, &title, &description, &tags, &bookmark.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		bookmark.Title = title.String
+		bookmark.Description = description.String
+		if err := json.Unmarshal([]byte(tags), &bookmark.Tags); err != nil {
+			return nil, err
+		}
+		result = append(result, bookmark)
+	}
+
+	return result, rows.Err()
+}
+
+// wantsHTML tells whether the request comes from a browser rather than an
+// API client.
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// writeShareError writes client errors as an HTML page for browsers, asking
+// for the password when the link needs one.
+func writeShareError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *utils.APIError
+	if !wantsHTML(r) || !errors.As(err, &apiErr) {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	writePage(w, apiErr.Status, sharePage{
+		Error:         apiErr.Message,
+		NeedsPassword: apiErr.Status == http.StatusUnauthorized,
+	})
+}
+
+type sharePage struct {
+	Item          *SharedItem
+	Error         string
+	NeedsPassword bool
+}
+
+func writePage(w http.ResponseWriter, status int, page sharePage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := pageTemplate.Execute(w, page); err != nil {
+		log.Printf("Error rendering share page: %v", err)
+	}
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{with .Item}}{{if .Bookmark}}{{or .Bookmark.Title .Bookmark.Url}}{{else}}{{.Collection.Name}}{{end}}{{else}}Shared bookmarks{{end}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; }
+ul { padding-left: 1.25rem; }
+li { margin-bottom: 0.75rem; }
+p { margin: 0.25rem 0; }
+.tags { color: #666; font-size: 0.9em; }
+</style>
+</head>
+<body>
+{{with .Item}}
+	{{if .Bookmark}}<ul>{{template "bookmark" .Bookmark}}</ul>{{else}}{{template "collection" .Collection}}{{end}}
+{{else}}
+	<p>{{.Error}}</p>
+	{{if .NeedsPassword}}
+	<form method="post">
+		<input type="password" name="password" aria-label="Password" autofocus required>
+		<button type="submit">Open</button>
+	</form>
+	{{end}}
+{{end}}
+</body>
+</html>
+{{define "bookmark"}}<li>
+	<a href="{{.Url}}" rel="noopener noreferrer">{{or .Title .Url}}</a>
+	{{with .Description}}<p>{{.}}</p>{{end}}
+	{{with .Tags}}<p class="tags">{{range .}}#{{.}} {{end}}</p>{{end}}
+</li>{{end}}
+{{define "collection"}}<section>
+	<h2>{{.Name}}</h2>
+	{{with .Bookmarks}}<ul>{{range .}}{{template "bookmark" .}}{{end}}</ul>{{end}}
+	{{range .Collections}}{{template "collection" .}}{{end}}
+</section>{{end}}`))
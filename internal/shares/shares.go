@@ -0,0 +1,305 @@
+package shares
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOKEN_BYTES is how much randomness goes into a share token, which is all
+// that protects links without a password.
+const TOKEN_BYTES = 32
+
+// MAX_PASSWORD_LENGTH is the most bcrypt can hash, in bytes.
+const MAX_PASSWORD_LENGTH = 72
+
+const (
+	CREATE_SHARE = `
+		INSERT INTO share_links (token, workspace_id, bookmark_id, collection_id, created_by, password_hash, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`
+	DELETE_SHARE            = `DELETE FROM share_links WHERE id = ? AND workspace_id = ?`
+	BOOKMARK_IN_WORKSPACE   = `SELECT EXISTS (SELECT 1 FROM bookmarks WHERE id = ? AND workspace_id = ? AND deleted_at IS NULL)`
+	COLLECTION_IN_WORKSPACE = `SELECT EXISTS (SELECT 1 FROM collections WHERE id = ? AND workspace_id = ?)`
+)
+
+// shareColumns lists the columns scanned by sharesScanner, for share links
+// aliased as s.
+const shareColumns = `s.id, s.token, s.bookmark_id, s.collection_id, s.created_by, s.password_hash IS NOT NULL,
+	s.expires_at, s.view_count, s.last_viewed_at, s.created_at`
+
+// Share is a public link to a bookmark or a collection, including everything
+// nested in it. Anyone with the link can see what it points to without an
+// account, unless it expired or needs a password.
+type Share struct {
+	Id    int    `json:"id"`
+	Token string `json:"token"`
+	// Path is where the shared item is served, relative to the API's origin.
+	Path         string     `json:"path"`
+	BookmarkId   *int       `json:"bookmark_id"`
+	CollectionId *int       `json:"collection_id"`
+	CreatedBy    int        `json:"created_by"`
+	HasPassword  bool       `json:"has_password"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	ViewCount    int        `json:"view_count"`
+	LastViewedAt *time.Time `json:"last_viewed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type shareRequest struct {
+	BookmarkId   *int       `json:"bookmark_id"`
+	CollectionId *int       `json:"collection_id"`
+	Password     string     `json:"password"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+// GetSharesHandler lists the share links of the current workspace, expired
+// ones included, optionally only those of a bookmark or a collection.
+func GetSharesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		filter := `s.workspace_id = ?`
+		args := []any{workspaceId}
+		for _, column := range []string{"bookmark_id", "collection_id"} {
+			if value := r.URL.Query().Get(column); value != "" {
+				filter += ` AND s.` + column + ` = ?`
+				args = append(args, value)
+			}
+		}
+
+		shares, err := getShares(db, filter, args...)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, shares)
+	}
+}
+
+// CreateShareHandler creates a share link for a bookmark or a collection of
+// the current workspace, optionally protected by a password and expiring at
+// expires_at.
+func CreateShareHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[shareRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if errs := request.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if err := checkTarget(db, workspaceId, request); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		var passwordHash, expiresAt any
+		if request.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+			if err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+			passwordHash = string(hash)
+		}
+		// Stored the way SQLite writes CURRENT_TIMESTAMP so the two compare.
+		if request.ExpiresAt != nil {
+			expiresAt = request.ExpiresAt.UTC().Format(time.DateTime)
+		}
+
+		token, err := newToken()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, CREATE_SHARE,
+			token, workspaceId, request.BookmarkId, request.CollectionId, userId, passwordHash, expiresAt)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		shares, err := getShares(db, `s.id = ?`, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if len(shares) == 0 {
+			utils.WriteError(w, r, utils.NotFound("Share link not found"))
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusCreated, shares[0])
+	}
+}
+
+// DeleteShareHandler revokes a share link. It stops working right away.
+func DeleteShareHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "share link")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, DELETE_SHARE, id, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Share link not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *shareRequest) validate() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	if (s.BookmarkId == nil) == (s.CollectionId == nil) {
+		errs.Add("bookmark_id", "Exactly one of bookmark_id and collection_id is required")
+	}
+	if len(s.Password) > MAX_PASSWORD_LENGTH {
+		errs.Add("password", fmt.Sprintf("Password should not be longer than %d bytes", MAX_PASSWORD_LENGTH))
+	}
+	if s.ExpiresAt != nil && !s.ExpiresAt.After(time.Now()) {
+		errs.Add("expires_at", "Expiry should be in the future")
+	}
+
+	return errs
+}
+
+// checkTarget makes sure the bookmark or collection being shared belongs to
+// the current workspace. Items shared with the user through a collection
+// can only be shared publicly from their own workspace.
+func checkTarget(execer utils.Execer, workspaceId string, request *shareRequest) error {
+	query, id, field, message := BOOKMARK_IN_WORKSPACE, request.BookmarkId, "bookmark_id", "Bookmark not found"
+	if request.CollectionId != nil {
+		query, id, field, message = COLLECTION_IN_WORKSPACE, request.CollectionId, "collection_id", "Collection not found"
+	}
+
+	stmt, err := execer.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var exists bool
+	if err := stmt.QueryRow(*id, workspaceId).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return utils.ValidationFailed(utils.FieldErrors{field: {message}})
+	}
+	return nil
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, TOKEN_BYTES)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// getShares lists the share links matching filter, a condition over share
+// links aliased as s, newest first.
+func getShares(execer utils.Execer, filter string, args ...any) ([]Share, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := execer.Prepare(`
+				SELECT ` + shareColumns + `
+				FROM share_links s
+				WHERE ` + filter + `
+				ORDER BY s.created_at DESC, s.id DESC`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(args...)
+			return stmt, rows, err
+		},
+		sharesScanner,
+	)
+}
+
+func sharesScanner(rows *sql.Rows) ([]Share, error) {
+	result := []Share{}
+
+	for rows.Next() {
+		var share Share
+		err := rows.Scan(
+			&share.Id,
+			&share.Token,
+			&share.BookmarkId,
+			&share.CollectionId,
+			&share.CreatedBy,
+			&share.HasPassword,
+			&share.ExpiresAt,
+			&share.ViewCount,
+			&share.LastViewedAt,
+			&share.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		share.Path = "/s/" + share.Token
+		result = append(result, share)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,310 @@
+package tags
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const (
+	CREATE_ALIAS = `INSERT INTO tag_aliases (workspace_id, tag_id, alias) VALUES(?, ?, ?)`
+	DELETE_ALIAS = `DELETE FROM tag_aliases WHERE id = ? AND tag_id = ? AND workspace_id = ?`
+	// DELETE_TAG_ALIAS drops the alias a tag is renamed to.
+	DELETE_TAG_ALIAS = `DELETE FROM tag_aliases WHERE tag_id = ? AND alias = ?`
+	// MOVE_ALIASES hands the aliases of a merged tag over to the tag it's
+	// merged into.
+	MOVE_ALIASES = `UPDATE tag_aliases SET tag_id = ? WHERE tag_id = ?`
+)
+
+// aliasColumns lists the columns scanned by Alias.scanFields, for aliases
+// aliased as a joined with their tag as t.
+const (
+	aliasColumns = `a.id, a.tag_id, t.name, a.alias, a.created_at`
+	aliasJoins   = `FROM tag_aliases a JOIN tags t ON a.tag_id = t.id`
+	FIND_ALIAS   = `SELECT ` + aliasColumns + ` ` + aliasJoins + ` WHERE a.id = ? AND a.tag_id = ? AND a.workspace_id = ?`
+)
+
+// Alias is another name for a tag. Tagging bookmarks or filtering them by an
+// alias uses the tag instead, so that go, golang and Golang can all end up as
+// the same tag.
+type Alias struct {
+	Id    int `json:"id"`
+	TagId int `json:"tag_id"`
+	// Tag is the name of the tag the alias stands for.
+	Tag       string    `json:"tag"`
+	Alias     string    `json:"alias"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (a *Alias) scanFields() []any {
+	return []any{&a.Id, &a.TagId, &a.Tag, &a.Alias, &a.CreatedAt}
+}
+
+type aliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+// GetAliasesHandler lists the aliases of a tag.
+func GetAliasesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "tag")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := Find(db, id, workspaceId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		aliases, err := utils.FindMany(
+			func() (*sql.Stmt, *sql.Rows, error) {
+				stmt, err := db.Prepare(`SELECT ` + aliasColumns + ` ` + aliasJoins + ` WHERE a.tag_id = ? AND a.workspace_id = ? ORDER BY a.alias`)
+				if err != nil {
+					return nil, nil, err
+				}
+				rows, err := stmt.Query(id, workspaceId)
+				return stmt, rows, err
+			},
+			aliasesScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, aliases)
+	}
+}
+
+// CreateAliasHandler gives a tag another name. Names of other tags and
+// aliases are taken, ignoring case.
+func CreateAliasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "tag")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[aliasRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		names := NormalizeNames([]string{request.Alias})
+		if len(names) == 0 {
+			utils.WriteError(w, r, utils.ValidationFailed(utils.FieldErrors{"alias": {"Alias is required"}}))
+			return
+		}
+		if errs := ValidateNames("alias", names); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+		request.Alias = names[0]
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		tag, err := findTag(tx, FIND_TAG, id, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if existing, err := findTag(tx, FIND_TAG_BY_NAME, request.Alias, workspaceId); err == nil {
+			utils.WriteError(w, r, utils.Conflict(fmt.Sprintf("A tag named %q already exists", existing.Name)))
+			return
+		} else if !isNotFound(err) {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if err := checkNotAlias(tx, request.Alias, workspaceId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(tx, CREATE_ALIAS, workspaceId, tag.Id, request.Alias)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		aliasId, err := result.LastInsertId()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		alias, err := utils.FindOne(
+			func() (*sql.Row, error) {
+				stmt, err := tx.Prepare(FIND_ALIAS)
+				if err != nil {
+					return nil, err
+				}
+				return stmt.QueryRow(aliasId, tag.Id, workspaceId), nil
+			},
+			aliasScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusCreated, alias)
+	}
+}
+
+func DeleteAliasHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "tag")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		aliasId, err := utils.PathId(r, "aliasId", "alias")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, DELETE_ALIAS, aliasId, id, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Alias not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResolveAliases replaces the aliases among names with the names of their
+// tags, and drops the names that then repeat. Names match aliases ignoring
+// case, the same way they match tags.
+func ResolveAliases(execer utils.Execer, names []string, workspaceId string) ([]string, error) {
+	if len(names) == 0 {
+		return names, nil
+	}
+
+	aliases, err := utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := execer.Prepare(`SELECT ` + aliasColumns + ` ` + aliasJoins + `
+				WHERE a.workspace_id = ? AND a.alias IN (` + utils.Placeholders(len(names)) + `)`)
+			if err != nil {
+				return nil, nil, err
+			}
+			args := []any{workspaceId}
+			for _, name := range names {
+				args = append(args, name)
+			}
+			rows, err := stmt.Query(args...)
+			return stmt, rows, err
+		},
+		aliasesScanner,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, alias := range aliases {
+			if EqualNames(alias.Alias, name) {
+				name = alias.Tag
+				break
+			}
+		}
+		if !slices.ContainsFunc(resolved, func(r string) bool { return EqualNames(r, name) }) {
+			resolved = append(resolved, name)
+		}
+	}
+	return resolved, nil
+}
+
+// checkNotAlias makes sure name isn't an alias of any of the workspace's
+// tags, since tags and aliases share their names.
+func checkNotAlias(execer utils.Execer, name, workspaceId string) error {
+	aliases, err := ResolveAliases(execer, []string{name}, workspaceId)
+	if err != nil {
+		return err
+	}
+	if aliases[0] != name {
+		return utils.Conflict(fmt.Sprintf("%q is an alias of the tag %q", name, aliases[0]))
+	}
+	return nil
+}
+
+func aliasScanner(row *sql.Row) (*Alias, error) {
+	alias := Alias{}
+	if err := row.Scan(alias.scanFields()...); err != nil {
+		return nil, err
+	}
+	return &alias, nil
+}
+
+func aliasesScanner(rows *sql.Rows) ([]Alias, error) {
+	aliases := []Alias{}
+	for rows.Next() {
+		alias := Alias{}
+		if err := rows.Scan(alias.scanFields()...); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
@@ -0,0 +1,202 @@
+package tags
+
+import (
+	"database/sql"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const (
+	DEFAULT_AUTOCOMPLETE_LIMIT = 10
+	MAX_AUTOCOMPLETE_LIMIT     = 50
+)
+
+// autocompleteTier selects the ids of the workspace's tags matching what was
+// typed equally well.
+type autocompleteTier struct {
+	query string
+	args  []any
+}
+
+// autocompleteTiers lists how tags can match q, best first: names starting
+// with it, aliases starting with it, nested segments or words starting with
+// it and names with its letters in order. Prefixes are ranges over the
+// workspace's unique names and aliases, whose indexes ignore case like the
+// names do, so they stay fast with thousands of tags. Everything sorting
+// between a prefix and the prefix followed by the last code point starts
+// with it. The other tiers have to look at every tag of the workspace, which
+// is why they only run when the prefixes leave room. Without q every tag
+// matches.
+func autocompleteTiers(workspaceId, q string) []autocompleteTier {
+	if q == "" {
+		return []autocompleteTier{{`SELECT t.id FROM tags t WHERE t.workspace_id = ?`, []any{workspaceId}}}
+	}
+
+	escaped := escapeLike(q)
+	fuzzy := "%"
+	for _, char := range q {
+		fuzzy += escapeLike(string(char)) + "%"
+	}
+
+	return []autocompleteTier{
+		{
+			`SELECT t.id FROM tags t WHERE t.workspace_id = ? AND t.name >= ? AND t.name < ? || char(1114111)`,
+			[]any{workspaceId, q, q},
+		},
+		{
+			`SELECT a.tag_id FROM tag_aliases a WHERE a.workspace_id = ? AND a.alias >= ? AND a.alias < ? || char(1114111)`,
+			[]any{workspaceId, q, q},
+		},
+		{
+			`SELECT t.id FROM tags t WHERE t.workspace_id = ? AND (t.name LIKE ? ESCAPE '\' OR t.name LIKE ? ESCAPE '\')`,
+			[]any{workspaceId, "%" + PATH_SEPARATOR + escaped + "%", "% " + escaped + "%"},
+		},
+		{
+			`SELECT t.id FROM tags t WHERE t.workspace_id = ? AND t.name LIKE ? ESCAPE '\'`,
+			[]any{workspaceId, fuzzy},
+		},
+	}
+}
+
+// AutocompleteTagsHandler suggests tags for what's being typed. Tags starting
+// with q come first, then tags with an alias starting with q, then tags with
+// a nested segment or word starting with q, and last tags with the letters of
+// q in order. Ties go to the tags on the most bookmarks, then to the ones used
+// most recently. Without q the most used tags are suggested.
+func AutocompleteTagsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		limit := DEFAULT_AUTOCOMPLETE_LIMIT
+		if parsed, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && parsed > 0 {
+			limit = min(parsed, MAX_AUTOCOMPLETE_LIMIT)
+		}
+
+		tags, err := autocomplete(db, workspaceId, q, limit)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, tags)
+	}
+}
+
+// autocomplete fills a page of limit tags tier by tier, stopping at the
+// first tier that fills it. Only the tags of the page are read in full, as
+// their counts and last use are costly to compute.
+func autocomplete(db *sql.DB, workspaceId, q string, limit int) ([]Tag, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := []int{}
+	for _, tier := range autocompleteTiers(workspaceId, q) {
+		if len(ids) == limit {
+			break
+		}
+		matched, err := rankTier(tx, tier, ids, limit-len(ids))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, matched...)
+	}
+	if len(ids) == 0 {
+		return []Tag{}, nil
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	tags, err := utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := tx.Prepare(`SELECT ` + tagColumns + ` FROM tags t WHERE t.id IN (` + utils.Placeholders(len(ids)) + `)`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(args...)
+			return stmt, rows, err
+		},
+		tagsScanner,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(tags, func(a, b Tag) int {
+		return slices.Index(ids, a.Id) - slices.Index(ids, b.Id)
+	})
+	return tags, nil
+}
+
+// rankTier returns the ids of up to limit tags outside the trash matched by
+// tier and not already picked, the ones on the most bookmarks first, then
+// the most recently used ones.
+func rankTier(tx *sql.Tx, tier autocompleteTier, picked []int, limit int) ([]int, error) {
+	args := slices.Clone(tier.args)
+	excluded := ""
+	if len(picked) > 0 {
+		excluded = ` AND t.id NOT IN (` + utils.Placeholders(len(picked)) + `)`
+		for _, id := range picked {
+			args = append(args, id)
+		}
+	}
+	args = append(args, limit)
+
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := tx.Prepare(`
+				SELECT t.id
+				FROM tags t
+				LEFT JOIN bookmark_tags b_t ON b_t.tag_id = t.id
+				LEFT JOIN bookmarks b ON b_t.bookmark_id = b.id AND b.deleted_at IS NULL
+				WHERE t.id IN (` + tier.query + `) AND t.deleted_at IS NULL` + excluded + `
+				GROUP BY t.id
+				ORDER BY COUNT(b.id) DESC, MAX(b.created_at) DESC, t.name
+				LIMIT ?`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(args...)
+			return stmt, rows, err
+		},
+		idsScanner,
+	)
+}
+
+func idsScanner(rows *sql.Rows) ([]int, error) {
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s, for patterns using \ as their
+// ESCAPE character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,251 @@
+package tags
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const MAX_MERGED_TAGS = 100
+
+const (
+	FIND_TAG         = `SELECT ` + tagColumns + ` FROM tags t WHERE t.id = ? AND t.workspace_id = ? AND t.deleted_at IS NULL`
+	FIND_TAG_BY_NAME = `SELECT ` + tagColumns + ` FROM tags t WHERE t.name = ? AND t.workspace_id = ? AND t.deleted_at IS NULL`
+	RENAME_TAG       = `UPDATE tags SET name = ? WHERE id = ? AND workspace_id = ? AND deleted_at IS NULL`
+	FIND_TRASHED_TAG = `SELECT id FROM tags WHERE name = ? AND workspace_id = ? AND deleted_at IS NOT NULL`
+	// RETAG_BOOKMARKS links the bookmarks of a merged tag to the tag it's
+	// merged into. Bookmarks that already have both keep a single pair.
+	RETAG_BOOKMARKS = `INSERT OR IGNORE INTO bookmark_tags (bookmark_id, tag_id) SELECT bookmark_id, ? FROM bookmark_tags WHERE tag_id = ?`
+	// Merged tags are deleted for good rather than trashed, restoring them
+	// would hand their bookmarks a tag they already have under another name.
+	PURGE_TAG = `DELETE FROM tags WHERE id = ? AND workspace_id = ?`
+)
+
+type mergeTagsRequest struct {
+	SourceIds []int `json:"source_ids"`
+	TargetId  int   `json:"target_id"`
+}
+
+// MergeTagsHandler merges tags into another one, which then tags every
+// bookmark they tagged. The merged tags are deleted.
+func MergeTagsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[mergeTagsRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if errs := request.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		target, err := findTag(tx, FIND_TAG, request.TargetId, workspaceId)
+		if err != nil {
+			if isNotFound(err) {
+				err = utils.ValidationFailed(utils.FieldErrors{"target_id": {"Tag not found"}})
+			}
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		for _, sourceId := range request.SourceIds {
+			if _, err := findTag(tx, FIND_TAG, sourceId, workspaceId); err != nil {
+				if isNotFound(err) {
+					err = utils.ValidationFailed(utils.FieldErrors{"source_ids": {fmt.Sprintf("Tag %d not found", sourceId)}})
+				}
+				utils.WriteError(w, r, err)
+				return
+			}
+
+			if err := mergeTag(tx, sourceId, target.Id, workspaceId); err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+		}
+
+		target, err = findTag(tx, FIND_TAG, target.Id, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, target)
+	}
+}
+
+func (m *mergeTagsRequest) validate() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	if m.TargetId == 0 {
+		errs.Add("target_id", "Target tag is required")
+	}
+
+	// Merging the target into itself would delete it, and listing a tag
+	// twice changes nothing.
+	sourceIds := []int{}
+	for _, id := range m.SourceIds {
+		if id != m.TargetId && !slices.Contains(sourceIds, id) {
+			sourceIds = append(sourceIds, id)
+		}
+	}
+	m.SourceIds = sourceIds
+
+	if len(m.SourceIds) == 0 {
+		errs.Add("source_ids", "At least one tag other than the target is required")
+	}
+	if len(m.SourceIds) > MAX_MERGED_TAGS {
+		errs.Add("source_ids", fmt.Sprintf("At most %d tags can be merged at once", MAX_MERGED_TAGS))
+	}
+
+	return errs
+}
+
+// renameTag gives tag a new name. When another tag already has it, tag is
+// merged into that one if merge is set, and the tag it was merged into is
+// returned. Renaming a tag to one of its aliases drops the alias, while the
+// aliases of other tags are taken.
+func renameTag(tx *sql.Tx, tag *Tag, name string, merge bool, workspaceId string) (*Tag, error) {
+	resolved, err := ResolveAliases(tx, []string{name}, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	if resolved[0] != name {
+		if resolved[0] != tag.Name {
+			return nil, utils.Conflict(fmt.Sprintf("%q is an alias of the tag %q", name, resolved[0]))
+		}
+		if _, err := utils.Exec(tx, DELETE_TAG_ALIAS, tag.Id, name); err != nil {
+			return nil, err
+		}
+	}
+
+	existing, err := findTag(tx, FIND_TAG_BY_NAME, name, workspaceId)
+	switch {
+	case err == nil && existing.Id == tag.Id:
+		// Only the case of the name changes.
+	case err == nil:
+		if !merge {
+			return nil, utils.Conflict(fmt.Sprintf("A tag named %q already exists, set merge to merge into it", name))
+		}
+		return existing, mergeTag(tx, tag.Id, existing.Id, workspaceId)
+	case !isNotFound(err):
+		return nil, err
+	}
+
+	// The new name shows on every tagged bookmark, so their ETags must change too.
+	if _, err := utils.Exec(tx, utils.BUMP_TAGGED_BOOKMARKS, tag.Id, workspaceId); err != nil {
+		return nil, err
+	}
+	if err := checkNotTrashed(tx, name, workspaceId); err != nil {
+		return nil, err
+	}
+	if _, err := utils.Exec(tx, RENAME_TAG, name, tag.Id, workspaceId); err != nil {
+		return nil, err
+	}
+
+	tag.Name = name
+	return tag, nil
+}
+
+// mergeTag moves the bookmarks and aliases of tag sourceId over to tag
+// targetId and deletes the source tag.
+func mergeTag(tx *sql.Tx, sourceId, targetId int, workspaceId string) error {
+	if _, err := utils.Exec(tx, utils.BUMP_TAGGED_BOOKMARKS, sourceId, workspaceId); err != nil {
+		return err
+	}
+	if _, err := utils.Exec(tx, RETAG_BOOKMARKS, targetId, sourceId); err != nil {
+		return err
+	}
+	if _, err := utils.Exec(tx, MOVE_ALIASES, targetId, sourceId); err != nil {
+		return err
+	}
+	_, err := utils.Exec(tx, PURGE_TAG, sourceId, workspaceId)
+	return err
+}
+
+// Find returns a tag of the workspace that isn't in the trash.
+func Find(execer utils.Execer, id, workspaceId string) (*Tag, error) {
+	return findTag(execer, FIND_TAG, id, workspaceId)
+}
+
+func findTag(execer utils.Execer, query string, key any, workspaceId string) (*Tag, error) {
+	tag, err := utils.FindOne(
+		func() (*sql.Row, error) {
+			stmt, err := execer.Prepare(query)
+			if err != nil {
+				return nil, err
+			}
+			return stmt.QueryRow(key, workspaceId), nil
+		},
+		tagScanner,
+	)
+	if isNotFound(err) {
+		return nil, utils.NotFound("Tag not found")
+	}
+	return tag, err
+}
+
+func tagScanner(row *sql.Row) (*Tag, error) {
+	tag := Tag{}
+	if err := row.Scan(tag.scanFields()...); err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// checkNotTrashed reports a conflict pointing at the trashed tag when name is
+// taken by a tag in the trash, which can be restored instead.
+func checkNotTrashed(execer utils.Execer, name, workspaceId string) error {
+	stmt, err := execer.Prepare(FIND_TRASHED_TAG)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var trashedId int
+	err = stmt.QueryRow(name, workspaceId).Scan(&trashedId)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	conflict := utils.Conflict(fmt.Sprintf("A tag named %q is in the trash", name))
+	conflict.Details = map[string]any{"fields": []string{"name"}, "trashed_id": trashedId}
+	return conflict
+}
+
+func isNotFound(err error) bool {
+	var apiErr *utils.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == utils.NOT_FOUND
+}
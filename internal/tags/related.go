@@ -0,0 +1,307 @@
+package tags
+
+import (
+	"cmp"
+	"database/sql"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const (
+	SORT_LIFT = "lift"
+
+	DEFAULT_RELATED_LIMIT = 20
+	MAX_RELATED_LIMIT     = 100
+)
+
+// COUNT_LIVE_BOOKMARKS counts the bookmarks outside the trash that lift
+// compares co-occurrences against.
+const COUNT_LIVE_BOOKMARKS = `SELECT COUNT(*) FROM bookmarks WHERE workspace_id = ? AND deleted_at IS NULL`
+
+// Co-occurrences only count bookmarks outside the trash. Both queries reach
+// the second tag of a pair through the primary key of bookmark_tags, so the
+// work grows with the number of pairs rather than with the whole table.
+const (
+	// FIND_RELATED_COUNTS pairs the tags appearing with a tag with the number
+	// of bookmarks they share.
+	FIND_RELATED_COUNTS = `SELECT other.tag_id, COUNT(*) AS count
+		FROM bookmark_tags this
+		JOIN bookmarks b ON this.bookmark_id = b.id AND b.deleted_at IS NULL
+		JOIN bookmark_tags other ON other.bookmark_id = this.bookmark_id AND other.tag_id <> this.tag_id
+		WHERE this.tag_id = ?
+		GROUP BY other.tag_id
+		HAVING COUNT(*) >= ?`
+	// FIND_TAG_EDGES lists every pair of the workspace's tags sharing
+	// bookmarks once, the lower id first.
+	FIND_TAG_EDGES = `SELECT source.tag_id, target.tag_id, COUNT(*) AS count
+		FROM bookmarks b
+		JOIN bookmark_tags source ON source.bookmark_id = b.id
+		JOIN bookmark_tags target ON target.bookmark_id = b.id AND target.tag_id > source.tag_id
+		WHERE b.workspace_id = ? AND b.deleted_at IS NULL
+		GROUP BY source.tag_id, target.tag_id
+		HAVING COUNT(*) >= ?
+		ORDER BY count DESC, source.tag_id, target.tag_id`
+)
+
+// RelatedTag is a tag used on the same bookmarks as another. Count is the
+// number of bookmarks they share, and Lift how many times more often they
+// appear together than they would if they were picked independently: above
+// 1 they go together, below 1 they tend to exclude each other.
+type RelatedTag struct {
+	Tag   Tag     `json:"tag"`
+	Count int     `json:"count"`
+	Lift  float64 `json:"lift"`
+}
+
+type TagEdge struct {
+	Source int     `json:"source"`
+	Target int     `json:"target"`
+	Count  int     `json:"count"`
+	Lift   float64 `json:"lift"`
+}
+
+// TagGraph has the workspace's tags as nodes, weighted by their number of
+// bookmarks, and an edge between every two tags sharing bookmarks.
+type TagGraph struct {
+	// BookmarkCount is the number of bookmarks outside the trash, which lift
+	// is computed against.
+	BookmarkCount int       `json:"bookmark_count"`
+	Nodes         []Tag     `json:"nodes"`
+	Edges         []TagEdge `json:"edges"`
+}
+
+type relatedQueryParams struct {
+	sort     string
+	limit    int
+	minCount int
+}
+
+// GetRelatedTagsHandler lists the tags used together with a tag, sharing the
+// most bookmarks first, or with the highest lift first with ?sort=lift.
+func GetRelatedTagsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "tag")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		queryParams := getRelatedQueryParams(r)
+
+		// Reading everything in one transaction keeps the counts consistent
+		// with each other.
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		tag, err := findTag(tx, FIND_TAG, id, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		total, err := countLiveBookmarks(tx, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		related, err := utils.FindMany(
+			func() (*sql.Stmt, *sql.Rows, error) {
+				stmt, err := tx.Prepare(`
+					SELECT ` + tagColumns + `, r.count
+					FROM (` + FIND_RELATED_COUNTS + `) r
+					JOIN tags t ON r.tag_id = t.id AND t.deleted_at IS NULL`)
+				if err != nil {
+					return nil, nil, err
+				}
+				rows, err := stmt.Query(tag.Id, queryParams.minCount)
+				return stmt, rows, err
+			},
+			relatedTagsScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		for i := range related {
+			related[i].Lift = lift(related[i].Count, tag.BookmarkCount, related[i].Tag.BookmarkCount, total)
+		}
+
+		slices.SortFunc(related, func(a, b RelatedTag) int {
+			byCount, byLift := compareDesc(float64(a.Count), float64(b.Count)), compareDesc(a.Lift, b.Lift)
+			if queryParams.sort == SORT_LIFT {
+				byCount, byLift = byLift, byCount
+			}
+			return cmp.Or(byCount, byLift, strings.Compare(strings.ToLower(a.Tag.Name), strings.ToLower(b.Tag.Name)))
+		})
+
+		utils.WriteJSON(w, http.StatusOK, related[:min(len(related), queryParams.limit)])
+	}
+}
+
+// GetTagGraphHandler exports the workspace's tags and how often they're used
+// together, for drawing or analysing the library as a graph. ?min_count
+// leaves out edges between tags sharing fewer bookmarks.
+func GetTagGraphHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		queryParams := getRelatedQueryParams(r)
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		graph := TagGraph{}
+		graph.BookmarkCount, err = countLiveBookmarks(tx, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		graph.Nodes, err = GetTags(tx, nil, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		slices.SortFunc(graph.Nodes, func(a, b Tag) int { return a.Id - b.Id })
+
+		edges, err := utils.FindMany(
+			func() (*sql.Stmt, *sql.Rows, error) {
+				stmt, err := tx.Prepare(FIND_TAG_EDGES)
+				if err != nil {
+					return nil, nil, err
+				}
+				rows, err := stmt.Query(workspaceId, queryParams.minCount)
+				return stmt, rows, err
+			},
+			tagEdgesScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		// Edges to tags in the trash are left out along with their nodes.
+		counts := map[int]int{}
+		for _, node := range graph.Nodes {
+			counts[node.Id] = node.BookmarkCount
+		}
+		graph.Edges = slices.DeleteFunc(edges, func(edge TagEdge) bool {
+			_, source := counts[edge.Source]
+			_, target := counts[edge.Target]
+			return !source || !target
+		})
+		for i, edge := range graph.Edges {
+			graph.Edges[i].Lift = lift(edge.Count, counts[edge.Source], counts[edge.Target], graph.BookmarkCount)
+		}
+
+		utils.WriteJSON(w, http.StatusOK, graph)
+	}
+}
+
+// lift compares how many bookmarks two tags share with how many they would
+// share by chance, given how many bookmarks each has among total.
+func lift(together, first, second, total int) float64 {
+	if first == 0 || second == 0 {
+		return 0
+	}
+	value := float64(together) * float64(total) / (float64(first) * float64(second))
+	return math.Round(value*1000) / 1000
+}
+
+func compareDesc(a, b float64) int {
+	switch {
+	case a > b:
+		return -1
+	case a < b:
+		return 1
+	}
+	return 0
+}
+
+func countLiveBookmarks(tx *sql.Tx, workspaceId string) (int, error) {
+	count := 0
+	err := tx.QueryRow(COUNT_LIVE_BOOKMARKS, workspaceId).Scan(&count)
+	return count, err
+}
+
+// getRelatedQueryParams reads the options of related tags and of the graph.
+// Pairs need to share min_count bookmarks, one by default.
+func getRelatedQueryParams(r *http.Request) relatedQueryParams {
+	queryParams := relatedQueryParams{sort: SORT_COUNT, limit: DEFAULT_RELATED_LIMIT, minCount: 1}
+
+	if sort := r.URL.Query().Get("sort"); sort == SORT_LIFT {
+		queryParams.sort = sort
+	}
+
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		queryParams.limit = min(limit, MAX_RELATED_LIMIT)
+	}
+
+	if minCount, err := strconv.Atoi(r.URL.Query().Get("min_count")); err == nil && minCount > 0 {
+		queryParams.minCount = minCount
+	}
+
+	return queryParams
+}
+
+func relatedTagsScanner(rows *sql.Rows) ([]RelatedTag, error) {
+	related := []RelatedTag{}
+	for rows.Next() {
+		tag := RelatedTag{}
+		if err := rows.Scan(append(tag.Tag.scanFields(), &tag.Count)...); err != nil {
+			return nil, err
+		}
+		related = append(related, tag)
+	}
+	return related, rows.Err()
+}
+
+func tagEdgesScanner(rows *sql.Rows) ([]TagEdge, error) {
+	edges := []TagEdge{}
+	for rows.Next() {
+		edge := TagEdge{}
+		if err := rows.Scan(&edge.Source, &edge.Target, &edge.Count); err != nil {
+			return nil, err
+		}
+		edges = append(edges, edge)
+	}
+	return edges, rows.Err()
+}
@@ -0,0 +1,371 @@
+package tags
+
+import (
+	"database/sql"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+const (
+	DEFAULT_SUGGESTION_LIMIT = 10
+	MAX_SUGGESTION_LIMIT     = 20
+	// MAX_SUGGESTION_HISTORY caps how many of the newest tagged bookmarks
+	// suggestions learn from.
+	MAX_SUGGESTION_HISTORY = 2000
+	// Suggestions scoring less than MIN_SUGGESTION_SCORE are left out.
+	MIN_SUGGESTION_SCORE = 0.1
+	// A term needs to be on MIN_TERM_BOOKMARKS past bookmarks before what
+	// they were tagged with says anything about it.
+	MIN_TERM_BOOKMARKS = 2
+)
+
+const (
+	REASON_CONTENT = "content"
+	REASON_HISTORY = "history"
+	REASON_RELATED = "related"
+)
+
+// How much each kind of evidence weighs in a suggestion's score, adding up
+// to 1.
+const (
+	contentWeight = 0.5
+	historyWeight = 0.3
+	relatedWeight = 0.2
+)
+
+// Terms count more the more telling the field they appear in is.
+const (
+	titleWeight       = 3
+	urlWeight         = 2
+	descriptionWeight = 2
+	textWeight        = 1
+)
+
+// stopwords are too common in titles and URLs to say anything about a page.
+var stopwords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		a an and are as at be but by can do for from has have how i if in into is it its my new
+		no not of on or our so that the their this to was we what when where which who why will
+		with you your about all also more most than then there these they use using vs via
+		http https www com org net io html htm php aspx index amp`) {
+		stopwords[word] = true
+	}
+}
+
+// Document is what tags are suggested for. BookmarkId is set when suggesting
+// for a saved bookmark, so it's left out of the history being learnt from.
+type Document struct {
+	BookmarkId  int
+	Url         string
+	Title       string
+	Description string
+	// Text is the text of the page, when it was fetched.
+	Text string
+	// Tags are the tags chosen so far, which aren't suggested again.
+	Tags []string
+}
+
+// Suggestion is one of the workspace's tags proposed for a document. Score
+// ranges from 0 to 1, and Reasons tell which evidence backs it: the tag
+// appears in the document's content, bookmarks with the same terms have it
+// in the history, or it's often used together with the chosen tags.
+type Suggestion struct {
+	Tag     string   `json:"tag"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+type candidate struct {
+	name string
+	// terms holds the terms of the tag's last segment and of each of its
+	// aliases, any of which the content can match.
+	terms [][]string
+}
+
+// Suggest scores the workspace's tags against a document, best first. It
+// combines how often a tag's name or aliases occur in the document, how often
+// past bookmarks sharing the document's terms got the tag, and how often the
+// tag goes together with the tags already chosen. Everything is worked out
+// from the workspace's own bookmarks.
+func Suggest(execer utils.Execer, workspaceId string, doc Document, limit int) ([]Suggestion, error) {
+	candidates, err := suggestionCandidates(execer, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen, err := ResolveAliases(execer, doc.Tags, workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	chosenIds := []int{}
+	for id, candidate := range candidates {
+		if slices.ContainsFunc(chosen, func(name string) bool { return EqualNames(name, candidate.name) }) {
+			chosenIds = append(chosenIds, id)
+			delete(candidates, id)
+		}
+	}
+
+	terms := map[string]float64{}
+	addTerms(terms, doc.Title, titleWeight)
+	addTerms(terms, doc.Url, urlWeight)
+	addTerms(terms, doc.Description, descriptionWeight)
+	addTerms(terms, doc.Text, textWeight)
+
+	history, related, err := suggestionHistory(execer, workspaceId, doc.BookmarkId, terms, chosenIds)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []Suggestion{}
+	for id, candidate := range candidates {
+		suggestion := Suggestion{Tag: candidate.name, Reasons: []string{}}
+		if content := contentScore(terms, candidate.terms); content > 0 {
+			suggestion.Score += contentWeight * content
+			suggestion.Reasons = append(suggestion.Reasons, REASON_CONTENT)
+		}
+		if history[id] > 0 {
+			suggestion.Score += historyWeight * history[id]
+			suggestion.Reasons = append(suggestion.Reasons, REASON_HISTORY)
+		}
+		if related[id] > 0 {
+			suggestion.Score += relatedWeight * related[id]
+			suggestion.Reasons = append(suggestion.Reasons, REASON_RELATED)
+		}
+
+		suggestion.Score = math.Round(suggestion.Score*1000) / 1000
+		if suggestion.Score >= MIN_SUGGESTION_SCORE {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+
+	slices.SortFunc(suggestions, func(a, b Suggestion) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(strings.ToLower(a.Tag), strings.ToLower(b.Tag))
+	})
+	return suggestions[:min(len(suggestions), limit)], nil
+}
+
+// contentScore grows with how often the rarest term of the best matching name
+// occurs in the document, approaching 1. Names match when all their terms
+// occur.
+func contentScore(terms map[string]float64, names [][]string) float64 {
+	best := 0.0
+	for _, nameTerms := range names {
+		if len(nameTerms) == 0 {
+			continue
+		}
+		frequency := math.Inf(1)
+		for _, term := range nameTerms {
+			frequency = min(frequency, terms[term])
+		}
+		best = max(best, frequency)
+	}
+	return best / (best + 1)
+}
+
+// suggestionCandidates maps the ids of the workspace's tags outside the trash
+// to their names and the terms they match content by.
+func suggestionCandidates(execer utils.Execer, workspaceId string) (map[int]*candidate, error) {
+	rows, err := utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := execer.Prepare(`
+				SELECT t.id, t.name, t.name FROM tags t
+				WHERE t.workspace_id = ? AND t.deleted_at IS NULL
+				UNION ALL
+				SELECT t.id, t.name, a.alias FROM tag_aliases a JOIN tags t ON a.tag_id = t.id AND t.deleted_at IS NULL
+				WHERE a.workspace_id = ?`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(workspaceId, workspaceId)
+			return stmt, rows, err
+		},
+		namedTermsScanner,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := map[int]*candidate{}
+	for _, row := range rows {
+		if candidates[row.id] == nil {
+			candidates[row.id] = &candidate{name: row.name}
+		}
+		// Nested tags match by their own segment, so that programming/go
+		// is suggested for pages about go.
+		match := row.match
+		if i := strings.LastIndex(match, PATH_SEPARATOR); i >= 0 {
+			match = match[i+len(PATH_SEPARATOR):]
+		}
+		candidates[row.id].terms = append(candidates[row.id].terms, slices.Sorted(maps.Keys(termCounts(match))))
+	}
+	return candidates, nil
+}
+
+// suggestionHistory learns from the workspace's newest tagged bookmarks. For
+// every tag it returns the highest share of past bookmarks with one of the
+// given terms that have the tag, and the highest share of past bookmarks with
+// one of the chosen tags that also have it. Shares are smoothed so that a
+// handful of bookmarks can't make a sure thing.
+func suggestionHistory(execer utils.Execer, workspaceId string, bookmarkId int, terms map[string]float64, chosenIds []int) (map[int]float64, map[int]float64, error) {
+	rows, err := utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := execer.Prepare(`
+				SELECT b.id, b.url, COALESCE(b.title, ''), COALESCE(b.description, ''), b_t.tag_id
+				FROM (
+					SELECT id, url, title, description FROM bookmarks
+					WHERE workspace_id = ? AND deleted_at IS NULL AND id <> ?
+						AND EXISTS (SELECT 1 FROM bookmark_tags WHERE bookmark_id = bookmarks.id)
+					ORDER BY created_at DESC, id DESC
+					LIMIT ?
+				) b
+				JOIN bookmark_tags b_t ON b_t.bookmark_id = b.id
+				JOIN tags t ON b_t.tag_id = t.id AND t.deleted_at IS NULL
+				ORDER BY b.id`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(workspaceId, bookmarkId, MAX_SUGGESTION_HISTORY)
+			return stmt, rows, err
+		},
+		taggedContentScanner,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Rows come grouped by bookmark, one per tag.
+	termBookmarks := map[string]int{}
+	termTags := map[string]map[int]int{}
+	chosenBookmarks := map[int]int{}
+	chosenTags := map[int]map[int]int{}
+	for start := 0; start < len(rows); {
+		end := start + 1
+		for end < len(rows) && rows[end].bookmarkId == rows[start].bookmarkId {
+			end++
+		}
+		bookmark := rows[start:end]
+		start = end
+
+		content := termCounts(bookmark[0].url, bookmark[0].title, bookmark[0].description)
+		for term := range content {
+			if terms[term] == 0 {
+				continue
+			}
+			termBookmarks[term]++
+			if termTags[term] == nil {
+				termTags[term] = map[int]int{}
+			}
+			for _, row := range bookmark {
+				termTags[term][row.tagId]++
+			}
+		}
+
+		for _, chosen := range bookmark {
+			if !slices.Contains(chosenIds, chosen.tagId) {
+				continue
+			}
+			chosenBookmarks[chosen.tagId]++
+			if chosenTags[chosen.tagId] == nil {
+				chosenTags[chosen.tagId] = map[int]int{}
+			}
+			for _, row := range bookmark {
+				chosenTags[chosen.tagId][row.tagId]++
+			}
+		}
+	}
+
+	history := map[int]float64{}
+	for term, count := range termBookmarks {
+		if count < MIN_TERM_BOOKMARKS {
+			continue
+		}
+		for tagId, tagged := range termTags[term] {
+			history[tagId] = max(history[tagId], float64(tagged)/float64(count+1))
+		}
+	}
+
+	related := map[int]float64{}
+	for chosenId, count := range chosenBookmarks {
+		for tagId, tagged := range chosenTags[chosenId] {
+			related[tagId] = max(related[tagId], float64(tagged)/float64(count+1))
+		}
+	}
+
+	return history, related, nil
+}
+
+// addTerms adds the terms of text to terms, each occurrence counting weight.
+func addTerms(terms map[string]float64, text string, weight float64) {
+	for term, count := range termCounts(text) {
+		terms[term] += weight * float64(count)
+	}
+}
+
+// termCounts splits texts into lowercase words of letters and digits,
+// leaving out single characters and stopwords, and counts them.
+func termCounts(texts ...string) map[string]int {
+	counts := map[string]int{}
+	for _, text := range texts {
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			if utf8.RuneCountInString(word) > 1 && !stopwords[word] {
+				counts[word]++
+			}
+		}
+	}
+	return counts
+}
+
+type namedTerms struct {
+	id    int
+	name  string
+	match string
+}
+
+func namedTermsScanner(rows *sql.Rows) ([]namedTerms, error) {
+	named := []namedTerms{}
+	for rows.Next() {
+		row := namedTerms{}
+		if err := rows.Scan(&row.id, &row.name, &row.match); err != nil {
+			return nil, err
+		}
+		named = append(named, row)
+	}
+	return named, rows.Err()
+}
+
+type taggedContent struct {
+	bookmarkId  int
+	url         string
+	title       string
+	description string
+	tagId       int
+}
+
+func taggedContentScanner(rows *sql.Rows) ([]taggedContent, error) {
+	tagged := []taggedContent{}
+	for rows.Next() {
+		row := taggedContent{}
+		if err := rows.Scan(&row.bookmarkId, &row.url, &row.title, &row.description, &row.tagId); err != nil {
+			return nil, err
+		}
+		tagged = append(tagged, row)
+	}
+	return tagged, rows.Err()
+}
@@ -3,77 +3,388 @@ package tags
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
 )
 
+const (
+	SORT_NAME     = "name"
+	SORT_COUNT    = "count"
+	SORT_RECENT   = "recent"
+	SORT_POSITION = "position"
+)
+
+const (
+	CREATE_TAG         = `INSERT INTO tags (user_id, workspace_id, name, color, icon, description, pinned, position) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`
+	UPDATE_TAG_DETAILS = `UPDATE tags SET color = ?, icon = ?, description = ?, pinned = ?, position = ? WHERE id = ? AND workspace_id = ? AND deleted_at IS NULL`
+)
+
+// A tag is unused when no bookmark has it. Bookmarks in the trash count, since
+// restoring them brings their tags back.
+const (
+	unusedTag         = `NOT EXISTS (SELECT 1 FROM bookmark_tags WHERE tag_id = t.id)`
+	TRASH_UNUSED_TAGS = `UPDATE tags AS t SET deleted_at = CURRENT_TIMESTAMP
+		WHERE t.workspace_id = ? AND t.deleted_at IS NULL AND ` + unusedTag
+)
+
+// liveTaggedBookmarks selects the bookmarks outside the trash tagged with the
+// tag aliased as t.
+const liveTaggedBookmarks = `SELECT b.created_at
+	FROM bookmark_tags b_t JOIN bookmarks b ON b_t.bookmark_id = b.id AND b.deleted_at IS NULL
+	WHERE b_t.tag_id = t.id`
+
+// tagColumns lists the columns scanned by Tag.scanFields, for tags aliased as
+// t. A tag was last used when the newest bookmark it tags was created.
+const tagColumns = `t.id, t.name, COALESCE(t.color, ''), COALESCE(t.icon, ''), COALESCE(t.description, ''),
+	t.pinned, t.position, t.created_at,
+	(SELECT COUNT(*) FROM (` + liveTaggedBookmarks + `)) AS bookmark_count,
+	(` + liveTaggedBookmarks + ` ORDER BY b.created_at DESC LIMIT 1) AS last_used_at`
+
+var tagSorts = map[string]string{
+	SORT_NAME:   "t.name",
+	SORT_COUNT:  "bookmark_count",
+	SORT_RECENT: "last_used_at",
+	// Pinned tags come first, in the order they were arranged in.
+	SORT_POSITION: "t.pinned DESC, t.position",
+}
+
 type Tag struct {
-	Id        int       `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
+	Id            int        `json:"id"`
+	Name          string     `json:"name"`
+	Color         string     `json:"color,omitempty"`
+	Icon          string     `json:"icon,omitempty"`
+	Description   string     `json:"description,omitempty"`
+	Pinned        bool       `json:"pinned"`
+	Position      int        `json:"position"`
+	BookmarkCount int        `json:"bookmark_count"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (t *Tag) scanFields() []any {
+	return []any{
+		&t.Id, &t.Name, &t.Color, &t.Icon, &t.Description, &t.Pinned, &t.Position,
+		&t.CreatedAt, &t.BookmarkCount, &t.LastUsedAt,
+	}
+}
+
+type tagsQueryParams struct {
+	sort   string
+	order  string
+	unused bool
+	pinned *bool
 }
 
 func GetTagsHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userId, httpStatus, err := utils.IsAuthenticated(r)
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tags, err := utils.FindMany(tagsListQueryRunner(db, workspaceId, getQueryParams(r)), tagsScanner)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, tags)
+	}
+}
+
+// CreateTagHandler creates a tag ahead of tagging bookmarks with it, so it can
+// be given a color, an icon and a description.
+func CreateTagHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[tagRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if errs := request.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		tx, err := db.Begin()
 		if err != nil {
-			http.Error(w, err.Error(), httpStatus)
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if existing, err := findTag(tx, FIND_TAG_BY_NAME, request.Name, workspaceId); err == nil {
+			utils.WriteError(w, r, utils.Conflict(fmt.Sprintf("A tag named %q already exists", existing.Name)))
+			return
+		} else if !isNotFound(err) {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := checkNotAlias(tx, request.Name, workspaceId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := checkNotTrashed(tx, request.Name, workspaceId); err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		tags, err := GetTags(db, nil, string(userId))
+		result, err := utils.Exec(tx, CREATE_TAG, append([]any{userId, workspaceId, request.Name}, request.values()...)...)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
 
+		tag, err := findTag(tx, FIND_TAG, id, workspaceId)
 		if err != nil {
-			http.Error(w, "Error getting tags: "+err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(tags)
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusCreated, tag)
+	}
+}
+
+// UpdateTagHandler applies a JSON Merge Patch to a tag. Renaming to the name
+// of another tag is a conflict, unless the patch sets merge, in which case the
+// tag is merged into the other one and the rest of the patch applies to it.
+func UpdateTagHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "tag")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		patch, err := utils.DecodeRequestBody[map[string]json.RawMessage](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		tag, err := findTag(tx, FIND_TAG, id, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, merge, errs := applyMergePatch(tag, *patch)
+		errs.Merge(request.validate())
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if tag.Name != request.Name {
+			renamed, err := renameTag(tx, tag, request.Name, merge, workspaceId)
+			if err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+			if renamed.Id != tag.Id {
+				request, _, _ = applyMergePatch(renamed, *patch)
+				request.validate()
+			}
+			tag = renamed
+		}
+
+		if _, err := utils.Exec(tx, UPDATE_TAG_DETAILS, append(request.values(), tag.Id, workspaceId)...); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		// Merging changed how many bookmarks the tag has.
+		tag, err = findTag(tx, FIND_TAG, tag.Id, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, tag)
 	}
 }
 
 func DeleteTagHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		if _, err := strconv.Atoi(id); err != nil || id == "" {
-			http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		id, err := utils.PathId(r, "id", "tag")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
+		defer tx.Rollback()
 
-		userId, httpStatus, err := utils.IsAuthenticated(r)
+		// Dropping the tag changes every tagged bookmark, so their ETags must change too.
+		if _, err := utils.Exec(tx, utils.BUMP_TAGGED_BOOKMARKS, id, workspaceId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(tx, utils.DELETE_TAG, id, workspaceId)
 		if err != nil {
-			http.Error(w, err.Error(), httpStatus)
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Tag not found"))
 			return
 		}
 
-		if _, err := utils.Exec(db, utils.DELETE_TAG, id, userId); err != nil {
-			http.Error(w, "Error deleting tag: "+err.Error(), http.StatusInternalServerError)
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-func CreateTags(tx utils.Execer, tags []string, userId string) error {
+// DeleteUnusedTagsHandler moves every tag no bookmark uses to the trash. It
+// insists on ?unused=true so that a bare DELETE can't empty the tag list.
+func DeleteUnusedTagsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if unused, err := strconv.ParseBool(r.URL.Query().Get("unused")); err != nil || !unused {
+			utils.WriteError(w, r, utils.BadRequest("Only unused tags can be deleted at once, pass unused=true"))
+			return
+		}
+
+		result, err := utils.Exec(db, TRASH_UNUSED_TAGS, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, map[string]int64{"deleted": deleted})
+	}
+}
+
+// CreateTags adds the missing tags to a workspace, recording userId as the
+// user who created them.
+func CreateTags(tx utils.Execer, tags []string, workspaceId, userId string) error {
 	if len(tags) == 0 {
 		return nil
 	}
 
-	query := "INSERT OR IGNORE INTO tags (user_id, name) VALUES"
+	// A tag in the trash keeps its name taken, so reusing the name takes it
+	// out of the trash. Only restoring it from the trash brings back the
+	// bookmarks it tagged: here it leaves them, so that it only tags the
+	// bookmarks it is reused for.
+	trashedFilter := "workspace_id = ? AND deleted_at IS NOT NULL AND name IN (" + utils.Placeholders(len(tags)) + ")"
+	trashedArgs := []any{workspaceId}
+	for _, tag := range tags {
+		trashedArgs = append(trashedArgs, tag)
+	}
+	detachQuery := "DELETE FROM bookmark_tags WHERE tag_id IN (SELECT id FROM tags WHERE " + trashedFilter + ")"
+	if _, err := utils.Exec(tx, detachQuery, trashedArgs...); err != nil {
+		return err
+	}
+	if _, err := utils.Exec(tx, "UPDATE tags SET deleted_at = NULL WHERE "+trashedFilter, trashedArgs...); err != nil {
+		return err
+	}
+
+	query := "INSERT OR IGNORE INTO tags (user_id, workspace_id, name) VALUES"
 	placeholders := make([]string, len(tags))
 	for i := range tags {
-		placeholders[i] = "(?, ?)"
+		placeholders[i] = "(?, ?, ?)"
 	}
 	query = query + " " + strings.Join(placeholders, ", ") + ";"
 
 	var args []any
 	for _, tag := range tags {
-		args = append(args, userId, tag)
+		args = append(args, userId, workspaceId, tag)
 	}
 
 	_, err := utils.Exec(tx, query, args...)
@@ -81,24 +392,18 @@ func CreateTags(tx utils.Execer, tags []string, userId string) error {
 	return err
 }
 
-func tagsQueryRunner(execer utils.Execer, tags []string, userId string) func() (*sql.Stmt, *sql.Rows, error) {
+func tagsQueryRunner(execer utils.Execer, tags []string, workspaceId string) func() (*sql.Stmt, *sql.Rows, error) {
 	return func() (*sql.Stmt, *sql.Rows, error) {
-		baseQuery := "SELECT id, name, created_at FROM tags WHERE user_id = ?"
+		baseQuery := "SELECT " + tagColumns + " FROM tags t WHERE t.workspace_id = ? AND t.deleted_at IS NULL"
 		var tagsFilter string
 
 		if len(tags) > 0 {
-			tagsFilter = " AND name IN ("
-			placeholders := make([]string, len(tags))
-			for i := range tags {
-				placeholders[i] = "?"
-			}
-
-			tagsFilter = tagsFilter + strings.Join(placeholders, ", ") + ")"
+			tagsFilter = " AND t.name IN (" + utils.Placeholders(len(tags)) + ")"
 		}
 
 		query := baseQuery + tagsFilter
 
-		args := []any{userId}
+		args := []any{workspaceId}
 		for _, tag := range tags {
 			args = append(args, tag)
 		}
@@ -115,12 +420,42 @@ func tagsQueryRunner(execer utils.Execer, tags []string, userId string) func() (
 	}
 }
 
+func tagsListQueryRunner(db *sql.DB, workspaceId string, queryParams tagsQueryParams) func() (*sql.Stmt, *sql.Rows, error) {
+	return func() (*sql.Stmt, *sql.Rows, error) {
+		filter := ""
+		args := []any{workspaceId}
+		if queryParams.unused {
+			filter += " AND " + unusedTag
+		}
+		if queryParams.pinned != nil {
+			filter += " AND t.pinned = ?"
+			args = append(args, *queryParams.pinned)
+		}
+		order := fmt.Sprintf("%s %s, t.name", tagSorts[queryParams.sort], queryParams.order)
+
+		stmt, err := db.Prepare(`
+			SELECT ` + tagColumns + `
+			FROM tags t
+			WHERE t.workspace_id = ? AND t.deleted_at IS NULL` + filter + `
+			ORDER BY ` + order)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rows, err := stmt.Query(args...)
+		if err != nil {
+			return stmt, nil, err
+		}
+		return stmt, rows, nil
+	}
+}
+
 func tagsScanner(rows *sql.Rows) ([]Tag, error) {
-	var tagsResult []Tag
+	tagsResult := []Tag{}
 
 	for rows.Next() {
 		tag := Tag{}
-		if err := rows.Scan(&tag.Id, &tag.Name, &tag.CreatedAt); err != nil {
+		if err := rows.Scan(tag.scanFields()...); err != nil {
 			return nil, err
 		}
 		tagsResult = append(tagsResult, tag)
@@ -129,8 +464,78 @@ func tagsScanner(rows *sql.Rows) ([]Tag, error) {
 	return tagsResult, rows.Err()
 }
 
-func GetTags(execer utils.Execer, tags []string, userId string) ([]Tag, error) {
-	return utils.FindMany(tagsQueryRunner(execer, tags, userId), tagsScanner)
+// getQueryParams reads the tag list options. Names and positions sort A to Z
+// by default, counts and recent use most first.
+func getQueryParams(r *http.Request) tagsQueryParams {
+	queryParams := tagsQueryParams{sort: SORT_NAME, order: "asc"}
+
+	if sort := r.URL.Query().Get("sort"); tagSorts[sort] != "" {
+		queryParams.sort = sort
+		if sort == SORT_COUNT || sort == SORT_RECENT {
+			queryParams.order = "desc"
+		}
+	}
+
+	switch order := r.URL.Query().Get("order"); order {
+	case "asc", "desc":
+		queryParams.order = order
+	}
+
+	if unused, err := strconv.ParseBool(r.URL.Query().Get("unused")); err == nil {
+		queryParams.unused = unused
+	}
+
+	if pinned, err := strconv.ParseBool(r.URL.Query().Get("pinned")); err == nil {
+		queryParams.pinned = &pinned
+	}
+
+	return queryParams
+}
+
+func GetTags(execer utils.Execer, tags []string, workspaceId string) ([]Tag, error) {
+	return utils.FindMany(tagsQueryRunner(execer, tags, workspaceId), tagsScanner)
+}
+
+// ForBookmarks returns the tags of each bookmark, sorted by name, leaving out
+// tags in the trash.
+func ForBookmarks(execer utils.Execer, bookmarkIds []int) (map[int][]Tag, error) {
+	result := map[int][]Tag{}
+	if len(bookmarkIds) == 0 {
+		return result, nil
+	}
+
+	args := make([]any, len(bookmarkIds))
+	for i, id := range bookmarkIds {
+		args[i] = id
+	}
+
+	stmt, err := execer.Prepare(`
+		SELECT b_t.bookmark_id, ` + tagColumns + `
+		FROM bookmark_tags b_t
+		JOIN tags t ON b_t.tag_id = t.id AND t.deleted_at IS NULL
+		WHERE b_t.bookmark_id IN (` + utils.Placeholders(len(bookmarkIds)) + `)
+		ORDER BY t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookmarkId int
+		var tag Tag
+		if err := rows.Scan(append([]any{&bookmarkId}, tag.scanFields()...)...); err != nil {
+			return nil, err
+		}
+		result[bookmarkId] = append(result[bookmarkId], tag)
+	}
+
+	return result, rows.Err()
 }
 
 func UpdateBookmarkTags(execer utils.Execer, bookmarkId int64, tagIds []int) error {
@@ -163,3 +568,24 @@ func TagIds(tags []Tag) []int {
 
 	return result
 }
+
+// RemoveBookmarkTags unlinks the named tags from a bookmark. The tags
+// themselves are kept, even when no bookmark uses them anymore.
+func RemoveBookmarkTags(execer utils.Execer, bookmarkId int64, names []string, workspaceId string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	query := `
+		DELETE FROM bookmark_tags
+		WHERE bookmark_id = ?
+		  AND tag_id IN (SELECT id FROM tags WHERE workspace_id = ? AND deleted_at IS NULL AND name IN (` + utils.Placeholders(len(names)) + `))`
+
+	args := []any{bookmarkId, workspaceId}
+	for _, name := range names {
+		args = append(args, name)
+	}
+
+	_, err := utils.Exec(execer, query, args...)
+	return err
+}
@@ -0,0 +1,161 @@
+package tags
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+// openTestDB creates the schema in a fresh directory with one user and their
+// workspace, whose ids are both "1".
+func openTestDB(t *testing.T) *sql.DB {
+	t.Chdir(t.TempDir())
+	db, err := utils.InitDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, query := range []string{
+		`INSERT INTO users (username, email, password_hash) VALUES ('alice', 'alice@example.com', '')`,
+		`INSERT INTO workspaces (name, personal, created_by) VALUES ('Personal', TRUE, 1)`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func mustExec(t *testing.T, db *sql.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateTagsReusesTrashedTagsWithoutTheirBookmarks(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO bookmarks (user_id, workspace_id, url) VALUES (1, 1, 'https://go.dev')`)
+	mustExec(t, db, `INSERT INTO tags (user_id, workspace_id, name, deleted_at) VALUES (1, 1, 'Go', CURRENT_TIMESTAMP)`)
+	mustExec(t, db, `INSERT INTO bookmark_tags (bookmark_id, tag_id) VALUES (1, 1)`)
+
+	if err := CreateTags(db, []string{"go", "rust"}, "1", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	var deletedAt sql.NullString
+	if err := db.QueryRow(`SELECT deleted_at FROM tags WHERE id = 1`).Scan(&deletedAt); err != nil {
+		t.Fatalf("the trashed tag is gone: %v", err)
+	}
+	if deletedAt.Valid {
+		t.Errorf("the trashed tag is still in the trash")
+	}
+
+	var links, version int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM bookmark_tags WHERE tag_id = 1`).Scan(&links); err != nil {
+		t.Fatal(err)
+	}
+	if links != 0 {
+		t.Errorf("the reused tag kept %d of its old bookmarks, expected none", links)
+	}
+	if err := db.QueryRow(`SELECT version FROM bookmarks WHERE id = 1`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("the old bookmark of the reused tag is at version %d, expected 1", version)
+	}
+}
+
+func TestCheckNotTrashed(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO tags (user_id, workspace_id, name, deleted_at) VALUES (1, 1, 'Go', CURRENT_TIMESTAMP)`)
+
+	if err := checkNotTrashed(db, "rust", "1"); err != nil {
+		t.Errorf("unexpected error for a free name: %v", err)
+	}
+
+	err := checkNotTrashed(db, "go", "1")
+	var apiErr *utils.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != utils.CONFLICT {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if details, _ := apiErr.Details.(map[string]any); details["trashed_id"] != 1 {
+		t.Errorf("expected the trashed tag's id in the details, got %v", apiErr.Details)
+	}
+}
+
+func TestEqualNames(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Go", "go", true},
+		{"lang/Go", "LANG/go", true},
+		{"go", "golang", false},
+		// Only ASCII letters fold, like COLLATE NOCASE.
+		{"Äpfel", "äpfel", false},
+		{"straße", "STRASSE", false},
+	}
+
+	for _, test := range tests {
+		if got := EqualNames(test.a, test.b); got != test.want {
+			t.Errorf("EqualNames(%q, %q) = %t, want %t", test.a, test.b, got, test.want)
+		}
+	}
+	if got := NormalizeNames([]string{"Go", "go", "Äpfel", "äpfel"}); !slices.Equal(got, []string{"Go", "Äpfel", "äpfel"}) {
+		t.Errorf("unexpected normalized names %q", got)
+	}
+}
+
+func TestAutocomplete(t *testing.T) {
+	db := openTestDB(t)
+	mustExec(t, db, `INSERT INTO tags (user_id, workspace_id, name) VALUES
+		(1, 1, 'go'), (1, 1, 'Golang'), (1, 1, 'rust'), (1, 1, 'lang/go'), (1, 1, 'gizmo'), (1, 1, 'python')`)
+	mustExec(t, db, `INSERT INTO tags (user_id, workspace_id, name, deleted_at) VALUES (1, 1, 'gone', CURRENT_TIMESTAMP)`)
+	mustExec(t, db, `INSERT INTO tag_aliases (workspace_id, tag_id, alias) VALUES (1, 3, 'gopher')`)
+	mustExec(t, db, `INSERT INTO bookmarks (user_id, workspace_id, url) VALUES (1, 1, 'https://go.dev'), (1, 1, 'https://golang.org')`)
+	mustExec(t, db, `INSERT INTO bookmark_tags (bookmark_id, tag_id) VALUES (1, 1), (1, 2), (2, 2), (1, 6)`)
+
+	names := func(tags []Tag) []string {
+		result := []string{}
+		for _, tag := range tags {
+			result = append(result, tag.Name)
+		}
+		return result
+	}
+
+	tests := []struct {
+		q     string
+		limit int
+		want  []string
+	}{
+		{"go", 10, []string{"Golang", "go", "rust", "lang/go", "gizmo"}},
+		{"GO", 2, []string{"Golang", "go"}},
+		{"go", 3, []string{"Golang", "go", "rust"}},
+		{"", 2, []string{"Golang", "go"}},
+		{"zzz", 10, []string{}},
+	}
+
+	for _, test := range tests {
+		tags, err := autocomplete(db, "1", test.q, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(tags); !slices.Equal(got, test.want) {
+			t.Errorf("autocomplete(%q, %d) = %q, want %q", test.q, test.limit, got, test.want)
+		}
+	}
+
+	// Counts are only computed for the tags returned, but still reported.
+	tags, err := autocomplete(db, "1", "golang", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].BookmarkCount != 2 || tags[0].LastUsedAt == nil {
+		t.Errorf("unexpected tag %+v", tags)
+	}
+}
@@ -0,0 +1,221 @@
+package tags
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+// PATH_SEPARATOR nests tags: lang/go and lang/rust are children of lang,
+// whether or not a tag named lang exists.
+const PATH_SEPARATOR = "/"
+
+type TagNode struct {
+	// Id is nil for nodes that only exist as the parent of other tags.
+	Id            *int       `json:"id"`
+	Name          string     `json:"name"`
+	Path          string     `json:"path"`
+	BookmarkCount int        `json:"bookmark_count"`
+	Children      []*TagNode `json:"children"`
+}
+
+type moveTagRequest struct {
+	// Parent is the path to move the tag under, empty for the top level.
+	Parent string `json:"parent"`
+	Merge  bool   `json:"merge"`
+}
+
+// SubtreeCondition matches the tags aliased as t that are named path or nested
+// under it, path being a SQL expression such as a bind parameter, which is
+// then bound three times. Names nested under path sort between path/ and
+// path0, '0' being the character right after the separator.
+func SubtreeCondition(path string) string {
+	return fmt.Sprintf("(t.name = %[1]s OR (t.name > %[1]s || '%[2]s' AND t.name < %[1]s || '0'))", path, PATH_SEPARATOR)
+}
+
+// GetTagTreeHandler lists the workspace's tags nested by their paths.
+func GetTagTreeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tags, err := utils.FindMany(tagsListQueryRunner(db, workspaceId, tagsQueryParams{sort: SORT_NAME, order: "asc"}), tagsScanner)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, buildTree(tags))
+	}
+}
+
+// MoveTagHandler moves a tag under another path together with the tags nested
+// under it. Like renaming, names taken by other tags are a conflict unless the
+// request asks to merge.
+func MoveTagHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "tag")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[moveTagRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		request.Parent = normalizePath(request.Parent)
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		tag, err := findTag(tx, FIND_TAG, id, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if isInSubtree(request.Parent, tag.Name) {
+			utils.WriteError(w, r, utils.ValidationFailed(utils.FieldErrors{"parent": {"A tag can't be moved under itself"}}))
+			return
+		}
+
+		name := tag.Name[strings.LastIndex(tag.Name, PATH_SEPARATOR)+1:]
+		if request.Parent != "" {
+			name = request.Parent + PATH_SEPARATOR + name
+		}
+
+		subtree, err := utils.FindMany(
+			func() (*sql.Stmt, *sql.Rows, error) {
+				stmt, err := tx.Prepare(`
+					SELECT ` + tagColumns + `
+					FROM tags t
+					WHERE t.workspace_id = ? AND t.deleted_at IS NULL AND ` + SubtreeCondition("?") + `
+					ORDER BY t.name`)
+				if err != nil {
+					return nil, nil, err
+				}
+				rows, err := stmt.Query(workspaceId, tag.Name, tag.Name, tag.Name)
+				return stmt, rows, err
+			},
+			tagsScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		renames := make([]string, len(subtree))
+		errs := utils.FieldErrors{}
+		for i, nested := range subtree {
+			// Names in the subtree start with the tag's name, though maybe in
+			// another case.
+			renames[i] = name + nested.Name[len(tag.Name):]
+			errs.Merge(ValidateNames("parent", renames[i:i+1]))
+		}
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		moved := tag
+		for i := range subtree {
+			if renames[i] == subtree[i].Name {
+				continue
+			}
+			renamed, err := renameTag(tx, &subtree[i], renames[i], request.Merge, workspaceId)
+			if err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+			if subtree[i].Id == tag.Id {
+				moved = renamed
+			}
+		}
+
+		// Merging changed how many bookmarks the tag has.
+		moved, err = findTag(tx, FIND_TAG, moved.Id, workspaceId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, moved)
+	}
+}
+
+// isInSubtree tells whether name is path or nested under it, ignoring case
+// like SubtreeCondition.
+func isInSubtree(name, path string) bool {
+	return EqualNames(name, path) ||
+		len(name) > len(path) && EqualNames(name[:len(path)+1], path+PATH_SEPARATOR)
+}
+
+// buildTree nests tags sorted by name under their parents, making up the
+// parents that aren't tags themselves. Paths ignore case, so lang/go and
+// Lang/rust share a parent named after whichever comes first.
+func buildTree(tags []Tag) []*TagNode {
+	roots := []*TagNode{}
+	nodes := map[string]*TagNode{}
+
+	var node func(path string) *TagNode
+	node = func(path string) *TagNode {
+		key := foldName(path)
+		if existing, ok := nodes[key]; ok {
+			return existing
+		}
+
+		created := &TagNode{Name: path, Path: path, Children: []*TagNode{}}
+		nodes[key] = created
+		if i := strings.LastIndex(path, PATH_SEPARATOR); i >= 0 {
+			created.Name = path[i+1:]
+			parent := node(path[:i])
+			parent.Children = append(parent.Children, created)
+		} else {
+			roots = append(roots, created)
+		}
+		return created
+	}
+
+	for _, tag := range tags {
+		tagNode := node(tag.Name)
+		tagNode.Id = &tag.Id
+		tagNode.BookmarkCount = tag.BookmarkCount
+	}
+
+	return roots
+}
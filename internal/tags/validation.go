@@ -0,0 +1,236 @@
+package tags
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+const (
+	MAX_TAGS_PER_BOOKMARK  = 20
+	MIN_TAG_LENGTH         = 1
+	MAX_TAG_LENGTH         = 50
+	MAX_ICON_LENGTH        = 32
+	MAX_DESCRIPTION_LENGTH = 500
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+var patchableFields = []string{"name", "merge", "color", "icon", "description", "pinned", "position"}
+
+type tagRequest struct {
+	Name string `json:"name"`
+	// Color is a hex RGB color such as #1e90ff.
+	Color string `json:"color"`
+	// Icon is an emoji or the name of an icon the client knows about.
+	Icon        string `json:"icon"`
+	Description string `json:"description"`
+	Pinned      bool   `json:"pinned"`
+	Position    int    `json:"position"`
+}
+
+// EqualNames tells whether two tag names are the same tag. Like COLLATE
+// NOCASE on the stored names, it only ignores the case of ASCII letters, so
+// Go and go are the same but Ä and ä are not.
+func EqualNames(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if foldASCII(a[i]) != foldASCII(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// foldName lowercases the ASCII letters of a tag name, giving a key that is
+// the same for names EqualNames considers equal.
+func foldName(name string) string {
+	folded := []byte(name)
+	for i := range folded {
+		folded[i] = foldASCII(folded[i])
+	}
+	return string(folded)
+}
+
+func foldASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// NormalizeNames trims tag names, collapses inner whitespace to single spaces
+// and drops blanks and duplicates while keeping the original order. Names
+// ignore case, so Go and go are duplicates and the first one is kept. Nested
+// names lose their empty segments and the whitespace around separators.
+func NormalizeNames(names []string) []string {
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		name = normalizePath(name)
+		if name == "" || slices.ContainsFunc(result, func(r string) bool { return EqualNames(r, name) }) {
+			continue
+		}
+		result = append(result, name)
+	}
+	return result
+}
+
+// validate normalizes the request in place and returns every rule it breaks.
+func (t *tagRequest) validate() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	names := NormalizeNames([]string{t.Name})
+	if len(names) == 0 {
+		errs.Add("name", "Name is required")
+	} else {
+		t.Name = names[0]
+		errs.Merge(ValidateNames("name", names))
+	}
+
+	t.Color = strings.ToLower(strings.TrimSpace(t.Color))
+	if t.Color != "" && !colorPattern.MatchString(t.Color) {
+		errs.Add("color", "Color should be a hex RGB color such as #1e90ff")
+	}
+
+	t.Icon = strings.TrimSpace(t.Icon)
+	if utf8.RuneCountInString(t.Icon) > MAX_ICON_LENGTH {
+		errs.Add("icon", fmt.Sprintf("Icon should be no longer than %d characters", MAX_ICON_LENGTH))
+	}
+
+	t.Description = strings.TrimSpace(t.Description)
+	if utf8.RuneCountInString(t.Description) > MAX_DESCRIPTION_LENGTH {
+		errs.Add("description", fmt.Sprintf("Description should be no longer than %d characters", MAX_DESCRIPTION_LENGTH))
+	}
+
+	if t.Position < 0 {
+		errs.Add("position", "Position should be 0 or more")
+	}
+
+	return errs
+}
+
+// values returns the color, icon, description, pinned flag and position to
+// store, with NULL for the texts left empty.
+func (t *tagRequest) values() []any {
+	var color, icon, description any
+	if t.Color != "" {
+		color = t.Color
+	}
+	if t.Icon != "" {
+		icon = t.Icon
+	}
+	if t.Description != "" {
+		description = t.Description
+	}
+	return []any{color, icon, description, t.Pinned, t.Position}
+}
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7386) document to an
+// existing tag. Absent members keep their current value and null members
+// clear it. The returned flag tells whether the patch asks to merge into the
+// tag that already has the new name.
+func applyMergePatch(existing *Tag, patch map[string]json.RawMessage) (*tagRequest, bool, utils.FieldErrors) {
+	errs := utils.FieldErrors{}
+	result := &tagRequest{
+		Name:        existing.Name,
+		Color:       existing.Color,
+		Icon:        existing.Icon,
+		Description: existing.Description,
+		Pinned:      existing.Pinned,
+		Position:    existing.Position,
+	}
+
+	for field := range patch {
+		if !slices.Contains(patchableFields, field) {
+			errs.Add(field, "Unknown or read-only field")
+		}
+	}
+
+	stringFields := map[string]*string{
+		"name":        &result.Name,
+		"color":       &result.Color,
+		"icon":        &result.Icon,
+		"description": &result.Description,
+	}
+	for field, target := range stringFields {
+		value, ok := patch[field]
+		if !ok {
+			continue
+		}
+		if isJSONNull(value) {
+			*target = ""
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			errs.Add(field, "Should be a string or null")
+		}
+	}
+
+	var merge bool
+	boolFields := map[string]*bool{
+		"pinned": &result.Pinned,
+		"merge":  &merge,
+	}
+	for field, target := range boolFields {
+		value, ok := patch[field]
+		if !ok {
+			continue
+		}
+		if isJSONNull(value) {
+			*target = false
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			errs.Add(field, "Should be a boolean or null")
+		}
+	}
+
+	if value, ok := patch["position"]; ok {
+		result.Position = 0
+		if !isJSONNull(value) {
+			if err := json.Unmarshal(value, &result.Position); err != nil {
+				errs.Add("position", "Should be an integer or null")
+			}
+		}
+	}
+
+	return result, merge, errs
+}
+
+func isJSONNull(value json.RawMessage) bool {
+	return string(value) == "null"
+}
+
+func normalizePath(name string) string {
+	segments := []string{}
+	for _, segment := range strings.Split(name, PATH_SEPARATOR) {
+		if segment = strings.Join(strings.Fields(segment), " "); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return strings.Join(segments, PATH_SEPARATOR)
+}
+
+// ValidateNames checks already normalized tag names against the documented limits.
+func ValidateNames(field string, names []string) utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
+	if len(names) > MAX_TAGS_PER_BOOKMARK {
+		errs.Add(field, fmt.Sprintf("At most %d tags are allowed", MAX_TAGS_PER_BOOKMARK))
+	}
+
+	for _, name := range names {
+		if length := utf8.RuneCountInString(name); length < MIN_TAG_LENGTH || length > MAX_TAG_LENGTH {
+			errs.Add(field, fmt.Sprintf("Tag %q should be between %d and %d characters", name, MIN_TAG_LENGTH, MAX_TAG_LENGTH))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,265 @@
+package trash
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/workspaces"
+)
+
+const (
+	DEFAULT_RETENTION_DAYS = 30
+	PURGE_INTERVAL         = time.Hour
+)
+
+const (
+	TRASHED_BOOKMARKS = `
+		SELECT b.id, b.url, b.title, b.deleted_at,
+		       (SELECT json_group_array(t.name)
+		        FROM bookmark_tags b_t JOIN tags t ON b_t.tag_id = t.id
+		        WHERE b_t.bookmark_id = b.id)
+		FROM bookmarks b
+		WHERE b.workspace_id = ? AND b.deleted_at IS NOT NULL
+		ORDER BY b.deleted_at DESC, b.id DESC`
+	TRASHED_TAGS = `
+		SELECT id, name, deleted_at
+		FROM tags
+		WHERE workspace_id = ? AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC`
+	RESTORE_BOOKMARK = `UPDATE bookmarks SET deleted_at = NULL, version = version + 1 WHERE id = ? AND workspace_id = ? AND deleted_at IS NOT NULL`
+	RESTORE_TAG      = `UPDATE tags SET deleted_at = NULL WHERE id = ? AND workspace_id = ? AND deleted_at IS NOT NULL`
+	PURGE_BOOKMARKS  = `DELETE FROM bookmarks WHERE deleted_at IS NOT NULL AND deleted_at < datetime('now', ?)`
+	PURGE_TAGS       = `DELETE FROM tags WHERE deleted_at IS NOT NULL AND deleted_at < datetime('now', ?)`
+)
+
+const (
+	TRASH_ITEM_TYPE_BOOKMARK = "bookmark"
+	TRASH_ITEM_TYPE_TAG      = "tag"
+)
+
+type TrashedBookmark struct {
+	Id        int       `json:"id"`
+	Url       string    `json:"url"`
+	Title     string    `json:"title,omitempty"`
+	Tags      []string  `json:"tags"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type TrashedTag struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type Trash struct {
+	Bookmarks []TrashedBookmark `json:"bookmarks"`
+	Tags      []TrashedTag      `json:"tags"`
+}
+
+func GetTrashHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		bookmarks, err := utils.FindMany(trashQueryRunner(db, TRASHED_BOOKMARKS, workspaceId), trashedBookmarksScanner)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tags, err := utils.FindMany(trashQueryRunner(db, TRASHED_TAGS, workspaceId), trashedTagsScanner)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, Trash{Bookmarks: bookmarks, Tags: tags})
+	}
+}
+
+// RestoreHandler takes a bookmark, or a tag when called with ?type=tag, out of
+// the trash. Tag links were kept while the item was trashed, so they come back
+// along with it.
+func RestoreHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "trash item")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := workspaces.Current(db, r, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		itemType := r.URL.Query().Get("type")
+		if itemType == "" {
+			itemType = TRASH_ITEM_TYPE_BOOKMARK
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		var result sql.Result
+		switch itemType {
+		case TRASH_ITEM_TYPE_BOOKMARK:
+			result, err = utils.Exec(tx, RESTORE_BOOKMARK, id, workspaceId)
+		case TRASH_ITEM_TYPE_TAG:
+			result, err = utils.Exec(tx, RESTORE_TAG, id, workspaceId)
+			if err == nil {
+				_, err = utils.Exec(tx, utils.BUMP_TAGGED_BOOKMARKS, id, workspaceId)
+			}
+		default:
+			utils.WriteError(w, r, utils.BadRequest("Type should be either bookmark or tag"))
+			return
+		}
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Item not found in trash"))
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Retention reads how long trashed items are kept from TRASH_RETENTION_DAYS.
+func Retention() time.Duration {
+	days := DEFAULT_RETENTION_DAYS
+	if value := os.Getenv("TRASH_RETENTION_DAYS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Printf("Ignoring invalid TRASH_RETENTION_DAYS %q, using %d", value, DEFAULT_RETENTION_DAYS)
+		} else {
+			days = parsed
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Purge permanently deletes bookmarks and tags trashed more than retention ago.
+func Purge(db *sql.DB, retention time.Duration) (int64, error) {
+	modifier := fmt.Sprintf("-%d seconds", int64(retention.Seconds()))
+	var purged int64
+
+	for _, query := range []string{PURGE_BOOKMARKS, PURGE_TAGS} {
+		result, err := utils.Exec(db, query, modifier)
+		if err != nil {
+			return purged, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return purged, err
+		}
+		purged += affected
+	}
+
+	return purged, nil
+}
+
+// StartPurging runs Purge right away and then every PURGE_INTERVAL until ctx is done.
+func StartPurging(ctx context.Context, db *sql.DB, retention time.Duration) {
+	ticker := time.NewTicker(PURGE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if purged, err := Purge(db, retention); err != nil {
+			log.Printf("Purging trash failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d items from trash", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func trashQueryRunner(db *sql.DB, query, workspaceId string) func() (*sql.Stmt, *sql.Rows, error) {
+	return func() (*sql.Stmt, *sql.Rows, error) {
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rows, err := stmt.Query(workspaceId)
+		if err != nil {
+			return stmt, nil, err
+		}
+		return stmt, rows, nil
+	}
+}
+
+func trashedBookmarksScanner(rows *sql.Rows) ([]TrashedBookmark, error) {
+	result := []TrashedBookmark{}
+
+	for rows.Next() {
+		var bookmark TrashedBookmark
+		var title sql.NullString
+		var tags string
+		if err := rows.Scan(&bookmark.Id, &bookmark.Url, &title, &bookmark.DeletedAt, &tags); err != nil {
+			return nil, err
+		}
+		bookmark.Title = title.String
+		if err := json.Unmarshal([]byte(tags), &bookmark.Tags); err != nil {
+			return nil, err
+		}
+		result = append(result, bookmark)
+	}
+
+	return result, rows.Err()
+}
+
+func trashedTagsScanner(rows *sql.Rows) ([]TrashedTag, error) {
+	result := []TrashedTag{}
+
+	for rows.Next() {
+		var tag TrashedTag
+		if err := rows.Scan(&tag.Id, &tag.Name, &tag.DeletedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, tag)
+	}
+
+	return result, rows.Err()
+}
@@ -2,7 +2,7 @@ package user
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/mail"
@@ -44,19 +44,18 @@ type User struct {
 
 func ProfileHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userId, status, err := utils.IsAuthenticated(r)
+		userId, err := utils.IsAuthenticated(r)
 		if err != nil {
-			http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+			utils.WriteError(w, r, err)
 			return
 		}
-		user, status, err := utils.FindOne(findUser(db, SEARCH_BY_ID, string(userId)), userScanner)
+		user, err := utils.FindOne(findUser(db, SEARCH_BY_ID, string(userId)), userScanner)
 		if err != nil {
-			http.Error(w, err.Error(), status)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(user.public())
+		utils.WriteJSON(w, http.StatusOK, user.public())
 	}
 }
 
@@ -64,24 +63,29 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := utils.DecodeRequestBody[User](r)
 		if err != nil {
-			http.Error(w, "Error decoding request body", http.StatusBadRequest)
+			utils.WriteError(w, r, err)
 			return
 		}
 
 		if user.Username == "" || user.Password == "" {
-			http.Error(w, "Invalid login credentials", http.StatusBadRequest)
+			utils.WriteError(w, r, utils.BadRequest("Username and password are required"))
 			return
 		}
 
-		savedUser, status, err := utils.FindOne(findUser(db, SEARCH_BY_USERNAME, user.Username), userScanner)
+		savedUser, err := utils.FindOne(findUser(db, SEARCH_BY_USERNAME, user.Username), userScanner)
+		var apiErr *utils.APIError
+		if errors.As(err, &apiErr) && apiErr.Code == utils.NOT_FOUND {
+			utils.WriteError(w, r, utils.Unauthorized("Invalid credentials"))
+			return
+		}
 		if err != nil {
-			http.Error(w, err.Error(), status)
+			utils.WriteError(w, r, err)
 			return
 		}
 
 		err = bcrypt.CompareHashAndPassword([]byte(savedUser.Password), []byte(user.Password))
 		if err != nil {
-			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			utils.WriteError(w, r, utils.Unauthorized("Invalid credentials"))
 			return
 		}
 
@@ -92,12 +96,11 @@ func LoginHandler(db *sql.DB) http.HandlerFunc {
 			SignedString(utils.SigningKey())
 
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(struct {
+		utils.WriteJSON(w, http.StatusOK, struct {
 			User  PublicUser `json:"user"`
 			Token string     `json:"token"`
 		}{User: savedUser.public(), Token: token})
@@ -108,41 +111,42 @@ func RegisterationHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := utils.DecodeRequestBody[User](r)
 		if err != nil {
-			http.Error(w, "Error decoding request body", http.StatusBadRequest)
+			utils.WriteError(w, r, err)
 			return
 		}
 
-		err = user.validate()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if errs := user.validate(); errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
 			return
 		}
 
 		err = user.save(db)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			utils.WriteError(w, r, err)
 			return
 		}
 		w.WriteHeader(http.StatusCreated)
 	}
 }
 
-func (u *User) validate() error {
+func (u *User) validate() utils.FieldErrors {
+	errs := utils.FieldErrors{}
+
 	u.Username = strings.TrimSpace(u.Username)
 	if len := utf8.RuneCountInString(u.Username); len < MIN_USERNAME_LENGTH || len > MAX_USERNAME_LENGTH {
-		return fmt.Errorf("Username should be no longer than %d characters and less than %d", MAX_USERNAME_LENGTH, MIN_USERNAME_LENGTH)
+		errs.Add("username", fmt.Sprintf("Username should be between %d and %d characters", MIN_USERNAME_LENGTH, MAX_USERNAME_LENGTH))
 	}
 
 	u.Email = strings.TrimSpace(u.Email)
 	if _, err := mail.ParseAddress(u.Email); err != nil {
-		return fmt.Errorf("Invalid email address: %s", u.Email)
+		errs.Add("email", fmt.Sprintf("Invalid email address: %s", u.Email))
 	}
 
 	if len := utf8.RuneCountInString(u.Password); len < MIN_PASSWORD_LENGTH {
-		return fmt.Errorf("Password should not be less than %d characters", MIN_PASSWORD_LENGTH)
+		errs.Add("password", fmt.Sprintf("Password should not be less than %d characters", MIN_PASSWORD_LENGTH))
 	}
 
-	return nil
+	return errs
 }
 
 func (u *User) save(db *sql.DB) error {
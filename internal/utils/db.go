@@ -1,11 +1,13 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
 
 	_ "github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
@@ -16,24 +18,80 @@ type Execer interface {
 }
 
 const (
-	CREATE_BOOKMARK         = `INSERT INTO bookmarks (user_id, url, title, description, notes) VALUES(?, ?, ?, ?, ?);`
-	GET_BOOKMARK            = `SELECT id, url, title, description, notes, created_at, updated_at FROM bookmarks WHERE id = ? AND user_id = ?;`
+	CREATE_BOOKMARK         = `INSERT INTO bookmarks (user_id, workspace_id, url, title, description, notes, status, is_favorite, is_archived, read_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, CASE WHEN ? = 'read' THEN CURRENT_TIMESTAMP END);`
+	FIND_TRASHED_BOOKMARK   = `SELECT id FROM bookmarks WHERE workspace_id = ? AND url = ? AND deleted_at IS NOT NULL;`
+	UPDATE_BOOKMARK         = `UPDATE bookmarks SET url = ?, title = ?, description = ?, notes = ?, status = ?, is_favorite = ?, is_archived = ?, read_at = CASE WHEN ? = 'read' THEN COALESCE(read_at, CURRENT_TIMESTAMP) END, version = version + 1 WHERE id = ? AND workspace_id = ? AND version = ? AND deleted_at IS NULL;`
 	CREATE_USER             = `INSERT INTO users (username, email, password_hash) VALUES(?, ?, ?);`
 	DELETE_BOOKMARK_TAG_IDS = `DELETE FROM bookmark_tags WHERE bookmark_id = ?`
-	DELETE_BOOKMARK         = `DELETE FROM bookmarks WHERE id = ? AND user_id = ?`
-	DELETE_TAG              = `DELETE FROM tags WHERE id = ? AND user_id = ?`
+	DELETE_BOOKMARK         = `UPDATE bookmarks SET deleted_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = ? AND workspace_id = ? AND version = ? AND deleted_at IS NULL`
+	DELETE_TAG              = `UPDATE tags SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND workspace_id = ? AND deleted_at IS NULL`
+	BUMP_BOOKMARK_VERSION   = `UPDATE bookmarks SET version = version + 1 WHERE id = ? AND workspace_id = ?`
+	BUMP_TAGGED_BOOKMARKS   = `UPDATE bookmarks SET version = version + 1 WHERE id IN (SELECT bookmark_id FROM bookmark_tags WHERE tag_id = ?) AND workspace_id = ? AND deleted_at IS NULL`
+)
+
+// Tables rebuilt by tableRebuilds are defined apart from the rest of the
+// schema, with their name left as a verb so the rebuilt copy can be created
+// under a temporary name.
+const (
+	bookmarksTable = `CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		workspace_id INTEGER NOT NULL,
+		url TEXT NOT NULL CHECK(url <> ''),
+		title VARCHAR(500),
+		description VARCHAR(2000),
+		notes TEXT,
+		status VARCHAR(10) NOT NULL DEFAULT 'unread' CHECK(status IN ('unread', 'reading', 'read')),
+		is_favorite BOOLEAN NOT NULL DEFAULT FALSE,
+		is_archived BOOLEAN NOT NULL DEFAULT FALSE,
+		read_at DATETIME,
+		version INTEGER NOT NULL DEFAULT 1,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		deleted_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+		UNIQUE(workspace_id, url)
+	);`
+	tagsTable = `CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		workspace_id INTEGER NOT NULL,
+		name VARCHAR(50) NOT NULL COLLATE NOCASE CHECK(name <> ''),
+		color VARCHAR(7),
+		icon VARCHAR(32),
+		description VARCHAR(500),
+		pinned BOOLEAN NOT NULL DEFAULT FALSE,
+		position INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		deleted_at DATETIME,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+		UNIQUE(workspace_id, name)
+	);`
+	collectionsTable = `CREATE TABLE IF NOT EXISTS %s (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		workspace_id INTEGER NOT NULL,
+		parent_id INTEGER,
+		name VARCHAR(100) NOT NULL CHECK(name <> ''),
+		position INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+		FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+		FOREIGN KEY (parent_id) REFERENCES collections(id) ON DELETE CASCADE
+	);`
 )
 
 func InitDatabase() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "./bookmarks.db")
+	// Pragmas go in the DSN so that every pooled connection enforces foreign
+	// keys and waits for locks held by the background jobs instead of failing.
+	db, err := sql.Open("sqlite3", "file:bookmarks.db?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := db.Exec(`PRAGMA foreign_keys = ON;`); err != nil {
-		return nil, fmt.Errorf("enabling foreign keys: %w", err)
-	}
-
 	schema := `
 	CREATE TABLE IF NOT EXISTS users (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -44,28 +102,44 @@ func InitDatabase() (*sql.DB, error) {
 		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 	);
 
-	CREATE TABLE IF NOT EXISTS bookmarks (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		url TEXT NOT NULL UNIQUE CHECK(url <> ''),
-		title VARCHAR(500),
-		description VARCHAR(2000),
-		notes TEXT,
-		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-	    UNIQUE(user_id, url)
+	CREATE TABLE IF NOT EXISTS workspaces (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    name VARCHAR(100) NOT NULL CHECK(name <> ''),
+	    personal BOOLEAN NOT NULL DEFAULT FALSE,
+	    created_by INTEGER NOT NULL,
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
 	);
 
-	CREATE TABLE IF NOT EXISTS tags (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	CREATE TABLE IF NOT EXISTS workspace_members (
+	    workspace_id INTEGER NOT NULL,
 	    user_id INTEGER NOT NULL,
-	    name VARCHAR(50) NOT NULL CHECK(name <> ''),
+	    role VARCHAR(10) NOT NULL CHECK(role IN ('member', 'admin', 'owner')),
 	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-	    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-	    UNIQUE(user_id, name)
+	    PRIMARY KEY (workspace_id, user_id),
+	    FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+	    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS workspace_invites (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    workspace_id INTEGER NOT NULL,
+	    email VARCHAR(255) NOT NULL,
+	    role VARCHAR(10) NOT NULL CHECK(role IN ('member', 'admin', 'owner')),
+	    invited_by INTEGER NOT NULL,
+	    token_hash VARCHAR(64),
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    expires_at DATETIME NOT NULL,
+	    FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+	    FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE CASCADE,
+	    UNIQUE(workspace_id, email)
 	);
 
+	` + fmt.Sprintf(bookmarksTable, "bookmarks") + `
+
+	` + fmt.Sprintf(tagsTable, "tags") + `
+
 	CREATE TABLE IF NOT EXISTS bookmark_tags (
 	    bookmark_id INTEGER NOT NULL,
 	    tag_id INTEGER NOT NULL,
@@ -74,11 +148,141 @@ func InitDatabase() (*sql.DB, error) {
 	    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
 	);
 
+	CREATE TABLE IF NOT EXISTS tag_aliases (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    workspace_id INTEGER NOT NULL,
+	    tag_id INTEGER NOT NULL,
+	    alias VARCHAR(50) NOT NULL COLLATE NOCASE CHECK(alias <> ''),
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+	    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+	    UNIQUE(workspace_id, alias)
+	);
+
+	CREATE TABLE IF NOT EXISTS tag_rules (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    user_id INTEGER NOT NULL,
+	    workspace_id INTEGER NOT NULL,
+	    domain VARCHAR(253),
+	    url_pattern VARCHAR(500),
+	    title_keywords TEXT NOT NULL DEFAULT '[]',
+	    tags TEXT NOT NULL DEFAULT '[]',
+	    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	    FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS bookmark_revisions (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    bookmark_id INTEGER NOT NULL,
+	    version INTEGER NOT NULL,
+	    url TEXT NOT NULL,
+	    title VARCHAR(500),
+	    description VARCHAR(2000),
+	    notes TEXT,
+	    tags TEXT NOT NULL DEFAULT '[]',
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    FOREIGN KEY (bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
+	    UNIQUE(bookmark_id, version)
+	);
+
+	CREATE TABLE IF NOT EXISTS annotations (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    bookmark_id INTEGER NOT NULL,
+	    user_id INTEGER NOT NULL,
+	    quote TEXT,
+	    selector TEXT,
+	    comment TEXT,
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    FOREIGN KEY (bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
+	    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	    CHECK(COALESCE(quote, '') <> '' OR COALESCE(comment, '') <> '')
+	);
+
+	` + fmt.Sprintf(collectionsTable, "collections") + `
+
+	CREATE TABLE IF NOT EXISTS collection_bookmarks (
+	    bookmark_id INTEGER PRIMARY KEY,
+	    collection_id INTEGER NOT NULL,
+	    position INTEGER NOT NULL DEFAULT 0,
+	    FOREIGN KEY (bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
+	    FOREIGN KEY (collection_id) REFERENCES collections(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS collection_members (
+	    collection_id INTEGER NOT NULL,
+	    user_id INTEGER NOT NULL,
+	    role VARCHAR(10) NOT NULL CHECK(role IN ('viewer', 'editor', 'owner')),
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    PRIMARY KEY (collection_id, user_id),
+	    FOREIGN KEY (collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+	    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS reminders (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    bookmark_id INTEGER NOT NULL,
+	    user_id INTEGER NOT NULL,
+	    remind_at DATETIME NOT NULL,
+	    recurrence VARCHAR(10) CHECK(recurrence IN ('daily', 'weekly', 'monthly', 'yearly')),
+	    fired_at DATETIME,
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    FOREIGN KEY (bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
+	    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS notifications (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    user_id INTEGER NOT NULL,
+	    bookmark_id INTEGER,
+	    message TEXT NOT NULL,
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    read_at DATETIME,
+	    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	    FOREIGN KEY (bookmark_id) REFERENCES bookmarks(id) ON DELETE SET NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS share_links (
+	    id INTEGER PRIMARY KEY AUTOINCREMENT,
+	    token VARCHAR(64) NOT NULL UNIQUE,
+	    workspace_id INTEGER NOT NULL,
+	    bookmark_id INTEGER,
+	    collection_id INTEGER,
+	    created_by INTEGER NOT NULL,
+	    password_hash VARCHAR(255),
+	    expires_at DATETIME,
+	    view_count INTEGER NOT NULL DEFAULT 0,
+	    last_viewed_at DATETIME,
+	    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	    FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+	    FOREIGN KEY (bookmark_id) REFERENCES bookmarks(id) ON DELETE CASCADE,
+	    FOREIGN KEY (collection_id) REFERENCES collections(id) ON DELETE CASCADE,
+	    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE,
+	    CHECK((bookmark_id IS NULL) <> (collection_id IS NULL))
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
 	CREATE INDEX IF NOT EXISTS idx_bookmarks_user_id ON bookmarks(user_id);
 	CREATE INDEX IF NOT EXISTS idx_bookmarks_url ON bookmarks(url);
 	CREATE INDEX IF NOT EXISTS idx_tags_user_id ON tags(user_id);
 	CREATE INDEX IF NOT EXISTS idx_tags_name ON tags(name);
+	CREATE INDEX IF NOT EXISTS idx_bookmark_tags_tag_id ON bookmark_tags(tag_id);
+	CREATE INDEX IF NOT EXISTS idx_tag_aliases_tag_id ON tag_aliases(tag_id);
+	CREATE INDEX IF NOT EXISTS idx_tag_rules_user_id ON tag_rules(user_id, workspace_id);
+	CREATE INDEX IF NOT EXISTS idx_collection_bookmarks_collection_id ON collection_bookmarks(collection_id, position);
+	CREATE INDEX IF NOT EXISTS idx_collection_members_user_id ON collection_members(user_id);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_workspaces_personal ON workspaces(created_by) WHERE personal;
+	CREATE INDEX IF NOT EXISTS idx_workspace_members_user_id ON workspace_members(user_id);
+	CREATE INDEX IF NOT EXISTS idx_workspace_invites_email ON workspace_invites(email);
+	CREATE INDEX IF NOT EXISTS idx_share_links_workspace_id ON share_links(workspace_id);
+	CREATE INDEX IF NOT EXISTS idx_annotations_bookmark_id ON annotations(bookmark_id);
+	CREATE INDEX IF NOT EXISTS idx_reminders_user_id ON reminders(user_id, remind_at);
+	CREATE INDEX IF NOT EXISTS idx_reminders_remind_at ON reminders(remind_at);
+	CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, created_at);
 
 	CREATE TRIGGER IF NOT EXISTS update_users_updated_at
 		AFTER UPDATE ON users
@@ -95,6 +299,38 @@ func InitDatabase() (*sql.DB, error) {
 	BEGIN
 	    UPDATE bookmarks SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
 	END;
+
+	CREATE TRIGGER IF NOT EXISTS update_annotations_updated_at
+	    AFTER UPDATE ON annotations
+	    FOR EACH ROW
+	    WHEN NEW.updated_at = OLD.updated_at
+	BEGIN
+	    UPDATE annotations SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS update_reminders_updated_at
+	    AFTER UPDATE ON reminders
+	    FOR EACH ROW
+	    WHEN NEW.updated_at = OLD.updated_at
+	BEGIN
+	    UPDATE reminders SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS update_workspaces_updated_at
+	    AFTER UPDATE ON workspaces
+	    FOR EACH ROW
+	    WHEN NEW.updated_at = OLD.updated_at
+	BEGIN
+	    UPDATE workspaces SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS update_collections_updated_at
+	    AFTER UPDATE ON collections
+	    FOR EACH ROW
+	    WHEN NEW.updated_at = OLD.updated_at
+	BEGIN
+	    UPDATE collections SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+	END;
 	`
 
 	_, err = db.Exec(schema)
@@ -102,10 +338,312 @@ func InitDatabase() (*sql.DB, error) {
 		return nil, err
 	}
 
+	if err := migrateColumns(db); err != nil {
+		return nil, fmt.Errorf("migrating columns: %w", err)
+	}
+
+	rebuilt, err := rebuildTables(db)
+	if err != nil {
+		return nil, fmt.Errorf("rebuilding tables: %w", err)
+	}
+	// Dropping the old tables also dropped their indexes and triggers.
+	if rebuilt {
+		if _, err := db.Exec(schema); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := db.Exec(migratedIndexes); err != nil {
+		return nil, err
+	}
+
 	log.Println("Database initialized successfully")
 	return db, nil
 }
 
+type columnMigration struct {
+	table      string
+	column     string
+	definition string
+}
+
+// columnMigrations lists columns added after a table was first released.
+// CREATE TABLE IF NOT EXISTS leaves existing tables untouched, so databases
+// created by older versions get these columns through ALTER TABLE instead.
+var columnMigrations = []columnMigration{
+	{"bookmarks", "version", "INTEGER NOT NULL DEFAULT 1"},
+	{"bookmarks", "deleted_at", "DATETIME"},
+	{"tags", "deleted_at", "DATETIME"},
+	{"bookmarks", "status", "VARCHAR(10) NOT NULL DEFAULT 'unread' CHECK(status IN ('unread', 'reading', 'read'))"},
+	{"bookmarks", "is_favorite", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"bookmarks", "is_archived", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"bookmarks", "read_at", "DATETIME"},
+	{"tags", "color", "VARCHAR(7)"},
+	{"tags", "icon", "VARCHAR(32)"},
+	{"tags", "description", "VARCHAR(500)"},
+	{"tags", "pinned", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"tags", "position", "INTEGER NOT NULL DEFAULT 0"},
+	{"workspace_invites", "token_hash", "VARCHAR(64)"},
+}
+
+func migrateColumns(db *sql.DB) error {
+	for _, migration := range columnMigrations {
+		var count int
+		err := db.QueryRow(
+			`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`,
+			migration.table, migration.column,
+		).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", migration.table, migration.column, migration.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("adding %s.%s: %w", migration.table, migration.column, err)
+		}
+	}
+	return nil
+}
+
+// migratedIndexes cover columns that older databases only get through
+// migrations, so they're created once those have run.
+const migratedIndexes = `
+	CREATE INDEX IF NOT EXISTS idx_bookmarks_workspace_id ON bookmarks(workspace_id);
+	CREATE INDEX IF NOT EXISTS idx_tags_workspace_id ON tags(workspace_id);
+	CREATE INDEX IF NOT EXISTS idx_collections_parent_id ON collections(workspace_id, parent_id, position);
+`
+
+// CREATE_PERSONAL_WORKSPACES gives every user who doesn't have one yet a
+// personal workspace named after them, and makes them its owner.
+const CREATE_PERSONAL_WORKSPACES = `
+	INSERT INTO workspaces (name, personal, created_by)
+	SELECT username, TRUE, id FROM users
+	WHERE id NOT IN (SELECT created_by FROM workspaces WHERE personal);
+
+	INSERT OR IGNORE INTO workspace_members (workspace_id, user_id, role)
+	SELECT id, created_by, 'owner' FROM workspaces WHERE personal;
+`
+
+type tableRebuild struct {
+	table      string
+	definition string
+	// column is missing from tables created before the rebuild, and gets
+	// filled from value, an expression over the old row aliased as old.
+	column string
+	value  string
+	// outdated optionally counts the tables that have column already but
+	// predate a later change to the definition.
+	outdated string
+	// prepare optionally checks the rows against definition and returns the
+	// definition to rebuild the table with, which may be less strict while
+	// rows would break the new constraints.
+	prepare func(tx *sql.Tx, definition string) (string, error)
+}
+
+const personalWorkspace = `(SELECT w.id FROM workspaces w WHERE w.personal AND w.created_by = old.user_id)`
+
+// caseSensitiveTags marks, in a comment of its definition, a tags table whose
+// case collisions were reported. It is only rebuilt again once they're gone.
+const caseSensitiveTags = "/* case sensitive until colliding tags are merged */"
+
+// tableRebuilds lists tables whose constraints changed after they were first
+// released, which ALTER TABLE can't do. Workspaces replaced users as the
+// owners of bookmarks, tags and collections, so their rows move to the
+// personal workspace of the user they belonged to. Tag names later became
+// case insensitive.
+var tableRebuilds = []tableRebuild{
+	{
+		table:      "tags",
+		definition: tagsTable,
+		column:     "workspace_id",
+		value:      personalWorkspace,
+		outdated: `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'tags' AND sql NOT LIKE '%COLLATE NOCASE%'
+			AND (sql NOT LIKE '%` + caseSensitiveTags + `%' OR NOT EXISTS (
+				SELECT 1 FROM tags GROUP BY workspace_id, lower(name) HAVING COUNT(*) > 1
+			))`,
+		prepare: reportTagCaseCollisions,
+	},
+	{table: "bookmarks", definition: bookmarksTable, column: "workspace_id", value: personalWorkspace},
+	{table: "collections", definition: collectionsTable, column: "workspace_id", value: personalWorkspace},
+}
+
+// rebuildTables recreates outdated tables following the procedure from
+// https://www.sqlite.org/lang_altertable.html#otheralter and reports whether
+// it had anything to do. Foreign keys are disabled meanwhile, otherwise
+// dropping the old tables would cascade to the rows referencing them.
+func rebuildTables(db *sql.DB) (bool, error) {
+	var pending []tableRebuild
+	for _, rebuild := range tableRebuilds {
+		var count int
+		err := db.QueryRow(
+			`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`,
+			rebuild.table, rebuild.column,
+		).Scan(&count)
+		if err != nil {
+			return false, err
+		}
+		outdated := count == 0
+		if !outdated && rebuild.outdated != "" {
+			if err := db.QueryRow(rebuild.outdated).Scan(&count); err != nil {
+				return false, err
+			}
+			outdated = count > 0
+		}
+		if outdated {
+			pending = append(pending, rebuild)
+		}
+	}
+	if len(pending) == 0 {
+		return false, nil
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return false, err
+	}
+	defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(CREATE_PERSONAL_WORKSPACES); err != nil {
+		return false, err
+	}
+
+	for _, rebuild := range pending {
+		if rebuild.prepare != nil {
+			if rebuild.definition, err = rebuild.prepare(tx, rebuild.definition); err != nil {
+				return false, fmt.Errorf("preparing %s: %w", rebuild.table, err)
+			}
+		}
+		if err := rebuildTable(tx, rebuild); err != nil {
+			return false, fmt.Errorf("rebuilding %s: %w", rebuild.table, err)
+		}
+	}
+
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return false, err
+	}
+	violated := rows.Next()
+	rows.Close()
+	if violated {
+		return false, fmt.Errorf("foreign key violations after rebuilding tables")
+	}
+
+	return true, tx.Commit()
+}
+
+func rebuildTable(tx *sql.Tx, rebuild tableRebuild) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, rebuild.table)
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return err
+		}
+		columns = append(columns, column)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	temporary := rebuild.table + "_rebuilt"
+	copied := strings.Join(columns, ", ")
+	inserted, selected := copied, copied
+	if !slices.Contains(columns, rebuild.column) {
+		inserted += ", " + rebuild.column
+		selected += ", " + rebuild.value
+	}
+	statements := []string{
+		fmt.Sprintf(rebuild.definition, temporary),
+		fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM %s old`,
+			temporary, inserted, selected, rebuild.table),
+		fmt.Sprintf(`DROP TABLE %s`, rebuild.table),
+		fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, temporary, rebuild.table),
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportTagCaseCollisions logs the tags whose names only differ in case,
+// which case insensitive names no longer tell apart. It doesn't merge them:
+// that is for their users to do through POST /api/tags/merge. Until then the
+// tags table keeps case sensitive names, marked as such so that they're only
+// reported once, and the first start after the last merge rebuilds it.
+func reportTagCaseCollisions(tx *sql.Tx, definition string) (string, error) {
+	// Tables this old still belong to users rather than workspaces.
+	owner := "user_id"
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('tags') WHERE name = 'workspace_id'`).Scan(&count); err != nil {
+		return "", err
+	}
+	if count > 0 {
+		owner = "workspace_id"
+	}
+
+	// SQLite's lower() folds ASCII letters only, like COLLATE NOCASE.
+	rows, err := tx.Query(fmt.Sprintf(`
+		SELECT t.id, t.name, k.id, k.name
+		FROM tags t
+		JOIN tags k ON k.%[1]s = t.%[1]s AND lower(k.name) = lower(t.name) AND k.id < t.id
+		WHERE NOT EXISTS (
+			SELECT 1 FROM tags c
+			WHERE c.%[1]s = t.%[1]s AND lower(c.name) = lower(t.name) AND c.id < k.id
+		)
+		ORDER BY t.id`, owner))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	collisions := 0
+	for rows.Next() {
+		var id, otherId int
+		var name, other string
+		if err := rows.Scan(&id, &name, &otherId, &other); err != nil {
+			return "", err
+		}
+		log.Printf("Tag %q (%d) collides with tag %q (%d) now that tag names ignore case, merge them with POST /api/tags/merge", name, id, other, otherId)
+		collisions++
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if collisions > 0 {
+		log.Printf("Tag names stay case sensitive until the %d colliding tags are merged", collisions)
+		return strings.Replace(definition, "COLLATE NOCASE", caseSensitiveTags, 1), nil
+	}
+	return definition, nil
+}
+
+// Placeholders returns n comma separated bind parameters for an IN (...) list.
+func Placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
 func Exec(db Execer, query string, args ...any) (sql.Result, error) {
 	stmt, err := db.Prepare(query)
 	if err != nil {
@@ -119,21 +657,21 @@ func Exec(db Execer, query string, args ...any) (sql.Result, error) {
 	return result, nil
 }
 
-func FindOne[T any](queryRunner func() (*sql.Row, error), rowScanner func(*sql.Row) (*T, error)) (*T, int, error) {
+func FindOne[T any](queryRunner func() (*sql.Row, error), rowScanner func(*sql.Row) (*T, error)) (*T, error) {
 	row, err := queryRunner()
 	if err != nil {
-		return nil, http.StatusInternalServerError, err
+		return nil, err
 	}
 
 	result, err := rowScanner(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, http.StatusNotFound, errors.New(http.StatusText(http.StatusNotFound))
+			return nil, NotFound(http.StatusText(http.StatusNotFound))
 		}
-		return nil, http.StatusInternalServerError, err
+		return nil, err
 	}
 
-	return result, http.StatusOK, nil
+	return result, nil
 }
 
 func FindMany[T any](
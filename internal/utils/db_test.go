@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTagCaseCollisionsAreNotMerged(t *testing.T) {
+	t.Chdir(t.TempDir())
+	db, err := InitDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tag names were case sensitive before, so Go and go could both exist.
+	caseSensitive := fmt.Sprintf(strings.Replace(tagsTable, " COLLATE NOCASE", "", 1), "tags")
+	for _, query := range []string{
+		`DROP TABLE tags`,
+		caseSensitive,
+		`INSERT INTO users (username, email, password_hash) VALUES ('alice', 'alice@example.com', '')`,
+		`INSERT INTO workspaces (name, personal, created_by) VALUES ('Personal', TRUE, 1)`,
+		`INSERT INTO tags (user_id, workspace_id, name) VALUES (1, 1, 'Go'), (1, 1, 'go'), (1, 1, 'rust')`,
+		`INSERT INTO bookmarks (user_id, workspace_id, url) VALUES (1, 1, 'https://go.dev')`,
+		`INSERT INTO bookmark_tags (bookmark_id, tag_id) VALUES (1, 1), (1, 2)`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	reopen := func() *sql.DB {
+		t.Helper()
+		db, err := InitDatabase()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return db
+	}
+	ignoresCase := func(db *sql.DB) bool {
+		t.Helper()
+		var definition string
+		if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'tags'`).Scan(&definition); err != nil {
+			t.Fatal(err)
+		}
+		return strings.Contains(definition, "COLLATE NOCASE")
+	}
+
+	db = reopen()
+	var tags, links int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM tags`).Scan(&tags); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM bookmark_tags`).Scan(&links); err != nil {
+		t.Fatal(err)
+	}
+	if tags != 3 || links != 2 {
+		t.Errorf("expected the colliding tags to be left alone, got %d tags and %d links", tags, links)
+	}
+	if ignoresCase(db) {
+		t.Errorf("expected tag names to stay case sensitive while tags collide")
+	}
+
+	// The collisions were reported once, so later starts leave the table alone.
+	if rebuilt, err := rebuildTables(db); err != nil || rebuilt {
+		t.Errorf("expected the reported tags table not to be rebuilt again, got %t, %v", rebuilt, err)
+	}
+
+	// Once the user merged them, the next start makes names ignore case.
+	if _, err := db.Exec(`DELETE FROM tags WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if db = reopen(); !ignoresCase(db) {
+		t.Errorf("expected tag names to ignore case once the collisions are gone")
+	}
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ncruces/go-sqlite3"
+)
+
+type ErrorCode string
+
+const (
+	VALIDATION_FAILED   ErrorCode = "VALIDATION_FAILED"
+	BAD_REQUEST         ErrorCode = "BAD_REQUEST"
+	UNAUTHORIZED        ErrorCode = "UNAUTHORIZED"
+	FORBIDDEN           ErrorCode = "FORBIDDEN"
+	NOT_FOUND           ErrorCode = "NOT_FOUND"
+	CONFLICT            ErrorCode = "CONFLICT"
+	PRECONDITION_FAILED ErrorCode = "PRECONDITION_FAILED"
+	INTERNAL_ERROR      ErrorCode = "INTERNAL_ERROR"
+)
+
+// APIError is an error that knows how it should be presented to API clients.
+// Anything returned by handlers' helpers that is not an APIError is treated
+// as an internal error and never shown to the client verbatim.
+type APIError struct {
+	Status  int       `json:"-"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	Details any       `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+func NewError(status int, code ErrorCode, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func BadRequest(message string) *APIError {
+	return NewError(http.StatusBadRequest, BAD_REQUEST, message)
+}
+
+func Unauthorized(message string) *APIError {
+	return NewError(http.StatusUnauthorized, UNAUTHORIZED, message)
+}
+
+func Forbidden(message string) *APIError {
+	return NewError(http.StatusForbidden, FORBIDDEN, message)
+}
+
+func NotFound(message string) *APIError {
+	return NewError(http.StatusNotFound, NOT_FOUND, message)
+}
+
+func Conflict(message string) *APIError {
+	return NewError(http.StatusConflict, CONFLICT, message)
+}
+
+func PreconditionFailed(message string) *APIError {
+	return NewError(http.StatusPreconditionFailed, PRECONDITION_FAILED, message)
+}
+
+func ValidationFailed(errs FieldErrors) *APIError {
+	err := NewError(http.StatusBadRequest, VALIDATION_FAILED, "Request validation failed")
+	err.Details = errs
+	return err
+}
+
+// fromDBError translates SQLite constraint violations into client errors.
+// It returns nil for errors that are not caused by the request itself.
+func fromDBError(err error) *APIError {
+	var sqliteErr *sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code() != sqlite3.CONSTRAINT {
+		return nil
+	}
+
+	var apiErr *APIError
+	switch sqliteErr.ExtendedCode() {
+	case sqlite3.CONSTRAINT_UNIQUE, sqlite3.CONSTRAINT_PRIMARYKEY:
+		apiErr = Conflict("Resource already exists")
+	case sqlite3.CONSTRAINT_FOREIGNKEY:
+		apiErr = BadRequest("Referenced resource does not exist")
+	default:
+		apiErr = BadRequest("Request violates a data constraint")
+	}
+
+	if fields := constraintFields(sqliteErr.Error()); len(fields) > 0 {
+		apiErr.Details = map[string]any{"fields": fields}
+	}
+	return apiErr
+}
+
+// constraintFieldNames maps the columns behind constraints that requests can
+// break to the request fields they're set from. Other columns, such as ids and
+// owners, are internal and never reported.
+var constraintFieldNames = map[string]string{
+	"bookmarks.url":           "url",
+	"tags.name":               "name",
+	"tag_aliases.alias":       "alias",
+	"collections.name":        "name",
+	"workspaces.name":         "name",
+	"workspace_invites.email": "email",
+	"users.username":          "username",
+	"users.email":             "email",
+}
+
+// constraintFields returns the request fields behind messages such as
+// "UNIQUE constraint failed: bookmarks.workspace_id, bookmarks.url".
+func constraintFields(message string) []string {
+	_, columns, found := strings.Cut(message, "constraint failed: ")
+	if !found {
+		return nil
+	}
+
+	var fields []string
+	for column := range strings.SplitSeq(columns, ",") {
+		if field, ok := constraintFieldNames[strings.TrimSpace(column)]; ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// WriteError writes err using the documented error envelope. Errors that
+// aren't APIErrors or constraint violations are logged with the request ID
+// and reported to the client as a generic internal error.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = fromDBError(err)
+	}
+
+	if apiErr == nil {
+		requestId := RequestId(r)
+		log.Printf("[%s] %s %s: %v", requestId, r.Method, r.URL.Path, err)
+		apiErr = NewError(http.StatusInternalServerError, INTERNAL_ERROR, "Internal server error")
+		apiErr.Details = map[string]any{"request_id": requestId}
+	}
+
+	WriteJSON(w, apiErr.Status, struct {
+		Error *APIError `json:"error"`
+	}{Error: apiErr})
+}
+
+func WriteJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConstraintFields(t *testing.T) {
+	tests := []struct {
+		message string
+		want    []string
+	}{
+		{"UNIQUE constraint failed: bookmarks.workspace_id, bookmarks.url", []string{"url"}},
+		{"UNIQUE constraint failed: tags.workspace_id, tags.name", []string{"name"}},
+		{"UNIQUE constraint failed: users.email", []string{"email"}},
+		{"UNIQUE constraint failed: bookmark_revisions.bookmark_id, bookmark_revisions.version", nil},
+		{"NOT NULL constraint failed: bookmarks.user_id", nil},
+		{"CHECK constraint failed: name <> ''", nil},
+		{"FOREIGN KEY constraint failed", nil},
+	}
+
+	for _, test := range tests {
+		if got := constraintFields(test.message); !slices.Equal(got, test.want) {
+			t.Errorf("constraintFields(%q) = %q, want %q", test.message, got, test.want)
+		}
+	}
+}
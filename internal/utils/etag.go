@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// VersionETag formats a resource version counter as a strong entity tag.
+func VersionETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// CheckIfMatch enforces the If-Match precondition (RFC 9110) for a write on a
+// resource whose current entity tag is etag. A missing header always passes.
+func CheckIfMatch(r *http.Request, etag string) error {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagListContains(header, etag, false) {
+		return nil
+	}
+	return PreconditionFailed("The resource has been modified since it was last fetched")
+}
+
+// IfNoneMatch reports whether the client's cached copy, identified by the
+// If-None-Match header, still matches etag.
+func IfNoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	return header != "" && etagListContains(header, etag, true)
+}
+
+func etagListContains(header, etag string, weak bool) bool {
+	for candidate := range strings.SplitSeq(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
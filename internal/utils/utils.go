@@ -3,10 +3,10 @@ package utils
 import (
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -18,7 +18,7 @@ func DecodeRequestBody[T any](r *http.Request) (*T, error) {
 	result := new(T)
 	err := json.NewDecoder(r.Body).Decode(result)
 	if err != nil {
-		return nil, err
+		return nil, BadRequest("Error decoding request body")
 	}
 
 	return result, nil
@@ -45,10 +45,10 @@ func SigningKey() []byte {
 	return key
 }
 
-func IsAuthenticated(r *http.Request) (UserId, int, error) {
+func IsAuthenticated(r *http.Request) (UserId, error) {
 	tokenStr, ok := bearerToken(r)
 	if !ok {
-		return "", http.StatusUnauthorized, errors.New("Missing/malformed token")
+		return "", Unauthorized("Missing/malformed token")
 	}
 
 	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (any, error) {
@@ -58,13 +58,38 @@ func IsAuthenticated(r *http.Request) (UserId, int, error) {
 		return SigningKey(), nil
 	})
 	if err != nil {
-		return "", http.StatusUnauthorized, errors.New("Invalid token: " + err.Error())
+		return "", Unauthorized("Invalid token")
 	}
 
 	userId, err := token.Claims.GetSubject()
-	if err != nil {
-		return "", http.StatusUnauthorized, errors.New("Invalid token: " + err.Error())
+	if err != nil || userId == "" {
+		return "", Unauthorized("Invalid token")
+	}
+
+	return UserId(userId), nil
+}
+
+// PathId returns the numeric path parameter called name, or a bad request
+// error mentioning resource when it's missing or malformed.
+func PathId(r *http.Request, name, resource string) (string, error) {
+	id := r.PathValue(name)
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", BadRequest("Invalid " + resource + " ID")
 	}
+	return id, nil
+}
 
-	return UserId(userId), http.StatusOK, nil
+// PrefersMinimal reports whether the client sent "Prefer: return=minimal"
+// (RFC 7240) and would rather not receive the resource in the response body.
+func PrefersMinimal(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for preference := range strings.SplitSeq(header, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(preference), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "return") &&
+				strings.EqualFold(strings.Trim(strings.TrimSpace(value), `"`), "minimal") {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,19 @@
+package utils
+
+// FieldErrors collects validation messages keyed by the offending request field,
+// so that every problem in a request can be reported at once.
+type FieldErrors map[string][]string
+
+func (e FieldErrors) Add(field, message string) {
+	e[field] = append(e[field], message)
+}
+
+func (e FieldErrors) Merge(other FieldErrors) {
+	for field, messages := range other {
+		e[field] = append(e[field], messages...)
+	}
+}
+
+func (e FieldErrors) HasErrors() bool {
+	return len(e) > 0
+}
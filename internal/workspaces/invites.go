@@ -0,0 +1,506 @@
+package workspaces
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/mail"
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+const INVITE_LIFETIME_DAYS = 7
+
+// INVITE_TOKEN_BYTES is how much randomness goes into an invite token, which
+// proves its holder received the invite at the address it was sent to.
+const INVITE_TOKEN_BYTES = 32
+
+const (
+	// CREATE_INVITE invites an email address, replacing any earlier invite of
+	// the same address to the workspace.
+	CREATE_INVITE = `
+		INSERT INTO workspace_invites (workspace_id, email, role, invited_by, token_hash, expires_at)
+		VALUES(?, ?, ?, ?, ?, datetime('now', ?))
+		ON CONFLICT(workspace_id, email) DO UPDATE SET
+			role = excluded.role,
+			invited_by = excluded.invited_by,
+			token_hash = excluded.token_hash,
+			created_at = CURRENT_TIMESTAMP,
+			expires_at = excluded.expires_at
+		RETURNING id`
+	DELETE_INVITE = `DELETE FROM workspace_invites WHERE id = ?`
+	// IS_MEMBER_EMAIL tells whether the user with the email address already
+	// belongs to the workspace.
+	IS_MEMBER_EMAIL = `
+		SELECT EXISTS (
+			SELECT 1 FROM workspace_members m JOIN users u ON m.user_id = u.id
+			WHERE m.workspace_id = ? AND u.email = ? COLLATE NOCASE
+		)`
+	USER_EMAIL = `SELECT email FROM users WHERE id = ?`
+)
+
+// inviteColumns lists the columns scanned by invitesScanner, for invites
+// aliased as i. Expired invites are left out by inviteJoins.
+const (
+	inviteColumns = `i.id, i.workspace_id, w.name, i.email, i.role, u.username, i.created_at, i.expires_at`
+	inviteJoins   = `
+		FROM workspace_invites i
+		JOIN workspaces w ON i.workspace_id = w.id
+		JOIN users u ON i.invited_by = u.id
+		WHERE i.expires_at > CURRENT_TIMESTAMP`
+)
+
+// Invite lets whoever received it at Email join a workspace with Role, by
+// presenting the token that was sent there.
+type Invite struct {
+	Id            int       `json:"id"`
+	WorkspaceId   int       `json:"workspace_id"`
+	WorkspaceName string    `json:"workspace_name"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	InvitedBy     string    `json:"invited_by"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	// Token is only returned when the invite is created. Only its hash is
+	// stored.
+	Token string `json:"token,omitempty"`
+}
+
+type inviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type inviteTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// CreateInviteHandler invites someone to a workspace by email address. They
+// don't need an account yet. The invite's token is emailed to the address
+// through mailer when there is one, and returned to the inviter in any case
+// so that they can pass it on themselves. Inviting takes the admin role, or
+// the owner role to invite owners.
+func CreateInviteHandler(db *sql.DB, mailer mail.Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "workspace")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[inviteRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		errs := utils.FieldErrors{}
+		request.Email = strings.ToLower(strings.TrimSpace(request.Email))
+		if _, err := netmail.ParseAddress(request.Email); err != nil {
+			errs.Add("email", fmt.Sprintf("Invalid email address: %s", request.Email))
+		}
+		if request.Role == "" {
+			request.Role = ROLE_MEMBER
+		}
+		if err := validateRole(request.Role); err != nil {
+			errs.Add("role", err.Error())
+		}
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		required := ROLE_ADMIN
+		if request.Role == ROLE_OWNER {
+			required = ROLE_OWNER
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		workspace, err := Find(tx, id, userId, required)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if workspace.Personal {
+			utils.WriteError(w, r, utils.Forbidden("Personal workspaces can't be shared"))
+			return
+		}
+
+		isMember, err := isMemberEmail(tx, id, request.Email)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if isMember {
+			conflict := utils.Conflict("A member of the workspace already uses this email address")
+			conflict.Details = map[string]any{"fields": []string{"email"}}
+			utils.WriteError(w, r, conflict)
+			return
+		}
+
+		token, err := newInviteToken()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		inviteId, err := createInvite(tx, id, request.Email, request.Role, userId, hashInviteToken(token))
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		invite, err := findInvite(db, inviteId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		invite.Token = token
+
+		// The invite stands even when it can't be emailed, the inviter still
+		// has the token.
+		if mailer != nil {
+			if err := sendInvite(r, mailer, invite); err != nil {
+				log.Printf("Couldn't email invite %d: %v", invite.Id, err)
+			}
+		}
+
+		utils.WriteJSON(w, http.StatusCreated, invite)
+	}
+}
+
+// GetInvitesHandler lists the pending invites to a workspace, for admins.
+func GetInvitesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "workspace")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := Find(db, id, userId, ROLE_ADMIN); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		invites, err := getInvites(db, `AND i.workspace_id = ?`, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, invites)
+	}
+}
+
+// RevokeInviteHandler withdraws a pending invite to a workspace.
+func RevokeInviteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "workspace")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		inviteId, err := utils.PathId(r, "inviteId", "invite")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := Find(db, id, userId, ROLE_ADMIN); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		result, err := utils.Exec(db, DELETE_INVITE+` AND workspace_id = ?`, inviteId, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			utils.WriteError(w, r, utils.NotFound("Invite not found"))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetMyInvitesHandler lists the pending invites sent to the user's email
+// address, from any workspace.
+func GetMyInvitesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		email, err := userEmail(db, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		invites, err := getInvites(db, `AND i.email = ? COLLATE NOCASE`, email)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, invites)
+	}
+}
+
+// AcceptInviteHandler makes the user a member of the workspace they were
+// invited to, with the role they were invited with, and responds with that
+// workspace. The request needs the invite's token.
+func AcceptInviteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		invite, err := userInvite(tx, r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(tx, ADD_MEMBER, invite.WorkspaceId, userId, invite.Role); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(tx, DELETE_INVITE, invite.Id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspace, err := Find(db, fmt.Sprint(invite.WorkspaceId), userId, ROLE_MEMBER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, workspace)
+	}
+}
+
+// DeclineInviteHandler discards an invite, given its token.
+func DeclineInviteHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := utils.IsAuthenticated(r); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		invite, err := userInvite(db, r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(db, DELETE_INVITE, invite.Id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// userInvite loads the pending invite from the request path, as long as the
+// request body has its token. The email address an account was registered
+// with isn't verified, so it can't tell who an invite was sent to.
+func userInvite(execer utils.Execer, r *http.Request) (*Invite, error) {
+	id, err := utils.PathId(r, "id", "invite")
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := utils.DecodeRequestBody[inviteTokenRequest](r)
+	if err != nil {
+		return nil, err
+	}
+	if request.Token == "" {
+		return nil, utils.ValidationFailed(utils.FieldErrors{"token": {"Token is required"}})
+	}
+
+	invites, err := getInvites(execer, `AND i.id = ? AND i.token_hash = ?`, id, hashInviteToken(request.Token))
+	if err != nil {
+		return nil, err
+	}
+	if len(invites) == 0 {
+		return nil, utils.NotFound("Invite not found")
+	}
+	return &invites[0], nil
+}
+
+func findInvite(execer utils.Execer, id int) (*Invite, error) {
+	invites, err := getInvites(execer, `AND i.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(invites) == 0 {
+		return nil, utils.NotFound("Invite not found")
+	}
+	return &invites[0], nil
+}
+
+// getInvites lists pending invites matching filter, a condition appended to
+// the WHERE clause of inviteJoins.
+func getInvites(execer utils.Execer, filter string, args ...any) ([]Invite, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := execer.Prepare(`
+				SELECT ` + inviteColumns + inviteJoins + ` ` + filter + `
+				ORDER BY i.created_at DESC, i.id DESC`)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(args...)
+			return stmt, rows, err
+		},
+		invitesScanner,
+	)
+}
+
+func invitesScanner(rows *sql.Rows) ([]Invite, error) {
+	result := []Invite{}
+
+	for rows.Next() {
+		var invite Invite
+		err := rows.Scan(
+			&invite.Id,
+			&invite.WorkspaceId,
+			&invite.WorkspaceName,
+			&invite.Email,
+			&invite.Role,
+			&invite.InvitedBy,
+			&invite.CreatedAt,
+			&invite.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, invite)
+	}
+
+	return result, rows.Err()
+}
+
+func createInvite(execer utils.Execer, workspaceId, email, role string, userId utils.UserId, tokenHash string) (int, error) {
+	stmt, err := execer.Prepare(CREATE_INVITE)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var id int
+	lifetime := fmt.Sprintf("+%d days", INVITE_LIFETIME_DAYS)
+	err = stmt.QueryRow(workspaceId, email, role, userId, tokenHash, lifetime).Scan(&id)
+	return id, err
+}
+
+func newInviteToken() (string, error) {
+	buf := make([]byte, INVITE_TOKEN_BYTES)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashInviteToken is what's stored in place of an invite token. Tokens are
+// random enough that a plain SHA-256 can't be reversed.
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sendInvite(r *http.Request, mailer mail.Mailer, invite *Invite) error {
+	subject := fmt.Sprintf("%s invited you to %s", invite.InvitedBy, invite.WorkspaceName)
+	body := fmt.Sprintf("Hi,\n\n%s invited you to join the workspace %s as %s.\n\n"+
+		"To accept, sign in and post this token to /api/invites/%d/accept:\n\n%s\n\nThe invite expires on %s.\n",
+		invite.InvitedBy, invite.WorkspaceName, invite.Role, invite.Id, invite.Token, invite.ExpiresAt.Format(time.DateOnly))
+	return mailer.Send(r.Context(), invite.Email, subject, body)
+}
+
+func isMemberEmail(execer utils.Execer, workspaceId, email string) (bool, error) {
+	stmt, err := execer.Prepare(IS_MEMBER_EMAIL)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	var isMember bool
+	err = stmt.QueryRow(workspaceId, email).Scan(&isMember)
+	return isMember, err
+}
+
+func userEmail(execer utils.Execer, userId utils.UserId) (string, error) {
+	stmt, err := execer.Prepare(USER_EMAIL)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	var email string
+	err = stmt.QueryRow(userId).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", utils.Unauthorized("Invalid token")
+	}
+	return email, err
+}
@@ -0,0 +1,282 @@
+package workspaces
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+const (
+	WORKSPACE_MEMBERS = `
+		SELECT u.id, u.username, m.role, m.created_at
+		FROM workspace_members m JOIN users u ON m.user_id = u.id
+		WHERE m.workspace_id = ?
+		ORDER BY m.created_at, u.id`
+	ADD_MEMBER    = `INSERT OR IGNORE INTO workspace_members (workspace_id, user_id, role) VALUES(?, ?, ?)`
+	SET_MEMBER    = `UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?`
+	REMOVE_MEMBER = `DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?`
+	COUNT_OWNERS  = `SELECT COUNT(*) FROM workspace_members WHERE workspace_id = ? AND role = 'owner'`
+)
+
+type Member struct {
+	UserId    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type memberRequest struct {
+	Role string `json:"role"`
+}
+
+// GetMembersHandler lists the members of a workspace, for any of them.
+func GetMembersHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "workspace")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := Find(db, id, userId, ROLE_MEMBER); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		members, err := getMembers(db, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, members)
+	}
+}
+
+// UpdateMemberHandler changes the role of a member. Admins can switch members
+// between the member and admin roles, making or demoting owners takes an
+// owner. A workspace always keeps at least one owner.
+func UpdateMemberHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "workspace")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		memberId, err := utils.PathId(r, "userId", "user")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[memberRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := validateRole(request.Role); err != nil {
+			utils.WriteError(w, r, utils.ValidationFailed(utils.FieldErrors{"role": {err.Error()}}))
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := Find(tx, id, userId, ROLE_ADMIN); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		current, err := checkMemberChange(tx, id, userId, memberId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if request.Role == ROLE_OWNER && current != ROLE_OWNER {
+			if _, err := Find(tx, id, userId, ROLE_OWNER); err != nil {
+				utils.WriteError(w, r, err)
+				return
+			}
+		}
+
+		if _, err := utils.Exec(tx, SET_MEMBER, request.Role, id, memberId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := checkOwners(tx, id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		members, err := getMembers(db, id)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		memberIdNumber, _ := strconv.Atoi(memberId)
+		for _, member := range members {
+			if member.UserId == memberIdNumber {
+				utils.WriteJSON(w, http.StatusOK, member)
+				return
+			}
+		}
+		utils.WriteError(w, r, utils.NotFound("Member not found"))
+	}
+}
+
+// RemoveMemberHandler removes a member from a workspace. Admins can remove
+// members and other admins, owners can remove anyone, and members can always
+// leave as long as the workspace keeps an owner. What they added to the
+// workspace stays there.
+func RemoveMemberHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "workspace")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		memberId, err := utils.PathId(r, "userId", "user")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := checkMemberChange(tx, id, userId, memberId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(tx, REMOVE_MEMBER, id, memberId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := checkOwners(tx, id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// checkMemberChange makes sure the user may change or remove a member of the
+// workspace and returns that member's current role. Users may always act on
+// themselves, others take the admin role, or the owner role when the member
+// is an owner.
+func checkMemberChange(execer utils.Execer, workspaceId string, userId utils.UserId, memberId string) (string, error) {
+	required := ROLE_MEMBER
+	if memberId != string(userId) {
+		required = ROLE_ADMIN
+	}
+	if _, err := Find(execer, workspaceId, userId, required); err != nil {
+		return "", err
+	}
+
+	role, err := RoleOf(execer, workspaceId, utils.UserId(memberId))
+	if err != nil {
+		return "", err
+	}
+	if role == "" {
+		return "", utils.NotFound("Member not found")
+	}
+	if role == ROLE_OWNER && memberId != string(userId) {
+		if _, err := Find(execer, workspaceId, userId, ROLE_OWNER); err != nil {
+			return "", err
+		}
+	}
+	return role, nil
+}
+
+// checkOwners fails when a change left the workspace without an owner.
+func checkOwners(execer utils.Execer, workspaceId string) error {
+	stmt, err := execer.Prepare(COUNT_OWNERS)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var owners int
+	if err := stmt.QueryRow(workspaceId).Scan(&owners); err != nil {
+		return err
+	}
+	if owners == 0 {
+		return utils.Conflict("A workspace needs at least one owner")
+	}
+	return nil
+}
+
+func getMembers(db *sql.DB, workspaceId string) ([]Member, error) {
+	return utils.FindMany(
+		func() (*sql.Stmt, *sql.Rows, error) {
+			stmt, err := db.Prepare(WORKSPACE_MEMBERS)
+			if err != nil {
+				return nil, nil, err
+			}
+			rows, err := stmt.Query(workspaceId)
+			return stmt, rows, err
+		},
+		membersScanner,
+	)
+}
+
+func membersScanner(rows *sql.Rows) ([]Member, error) {
+	result := []Member{}
+
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.UserId, &member.Username, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, member)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,446 @@
+package workspaces
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/segmentationfaulter/bookmarks-manager-api/internal/utils"
+)
+
+const MAX_WORKSPACE_NAME_LENGTH = 100
+
+// WORKSPACE_HEADER selects the workspace a request acts on. Bookmarks, tags
+// and collections all belong to a workspace, and requests without the header
+// act on the user's personal workspace.
+const WORKSPACE_HEADER = "X-Workspace-Id"
+
+// Roles a user can hold in a workspace, from least to most privileged.
+// Members can read and change everything in the workspace, admins can also
+// rename it and manage its members and invitations, owners can also make
+// other owners and delete it.
+const (
+	ROLE_MEMBER = "member"
+	ROLE_ADMIN  = "admin"
+	ROLE_OWNER  = "owner"
+)
+
+var roles = []string{ROLE_MEMBER, ROLE_ADMIN, ROLE_OWNER}
+
+const (
+	CREATE_WORKSPACE = `INSERT INTO workspaces (name, created_by) VALUES(?, ?)`
+	RENAME_WORKSPACE = `UPDATE workspaces SET name = ? WHERE id = ?`
+	DELETE_WORKSPACE = `DELETE FROM workspaces WHERE id = ? AND NOT personal`
+	WORKSPACE_ROLE   = `SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?`
+	FIND_PERSONAL    = `SELECT id FROM workspaces WHERE personal AND created_by = ?`
+	CREATE_PERSONAL  = `INSERT OR IGNORE INTO workspaces (name, personal, created_by) SELECT username, TRUE, id FROM users WHERE id = ?`
+)
+
+// workspaceColumns lists the columns scanned by Workspace.scanFields, for
+// workspaces aliased as w joined with the requesting user's membership as m.
+const workspaceColumns = `w.id, w.name, w.personal, w.created_by,
+	(SELECT COUNT(*) FROM workspace_members WHERE workspace_id = w.id),
+	m.role, w.created_at, w.updated_at`
+
+type Workspace struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	// Personal workspaces are created for every user and can't be shared
+	// nor deleted.
+	Personal    bool `json:"personal"`
+	CreatedBy   int  `json:"created_by"`
+	MemberCount int  `json:"member_count"`
+	// Role is the one the requesting user holds in the workspace.
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type workspaceRequest struct {
+	Name string `json:"name"`
+}
+
+// GetWorkspacesHandler lists the workspaces the user belongs to, their
+// personal workspace first.
+func GetWorkspacesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := personalWorkspace(db, userId); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaces, err := utils.FindMany(
+			func() (*sql.Stmt, *sql.Rows, error) {
+				stmt, err := db.Prepare(`
+					SELECT ` + workspaceColumns + `
+					FROM workspaces w
+					JOIN workspace_members m ON m.workspace_id = w.id AND m.user_id = ?
+					ORDER BY w.personal DESC, w.name, w.id`)
+				if err != nil {
+					return nil, nil, err
+				}
+				rows, err := stmt.Query(userId)
+				return stmt, rows, err
+			},
+			workspacesScanner,
+		)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, workspaces)
+	}
+}
+
+func GetWorkspaceHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "workspace")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspace, err := Find(db, id, userId, ROLE_MEMBER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, workspace)
+	}
+}
+
+// CreateWorkspaceHandler creates a shared workspace owned by the user, who
+// can then invite others to it.
+func CreateWorkspaceHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[workspaceRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		name, errs := validateName(request.Name)
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		result, err := utils.Exec(tx, CREATE_WORKSPACE, name, userId)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspaceId, err := result.LastInsertId()
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(tx, ADD_MEMBER, workspaceId, userId, ROLE_OWNER); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		id := strconv.FormatInt(workspaceId, 10)
+		workspace, err := Find(db, id, userId, ROLE_MEMBER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", "/api/workspaces/"+id)
+		utils.WriteJSON(w, http.StatusCreated, workspace)
+	}
+}
+
+// RenameWorkspaceHandler changes a workspace's name, which admins may do.
+func RenameWorkspaceHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "workspace")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		request, err := utils.DecodeRequestBody[workspaceRequest](r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		name, errs := validateName(request.Name)
+		if errs.HasErrors() {
+			utils.WriteError(w, r, utils.ValidationFailed(errs))
+			return
+		}
+
+		if _, err := Find(db, id, userId, ROLE_ADMIN); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		if _, err := utils.Exec(db, RENAME_WORKSPACE, name, id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspace, err := Find(db, id, userId, ROLE_MEMBER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		utils.WriteJSON(w, http.StatusOK, workspace)
+	}
+}
+
+// DeleteWorkspaceHandler deletes a workspace along with its bookmarks, tags
+// and collections. Only owners can delete a workspace, and personal
+// workspaces can't be deleted.
+func DeleteWorkspaceHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := utils.PathId(r, "id", "workspace")
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		userId, err := utils.IsAuthenticated(r)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		workspace, err := Find(db, id, userId, ROLE_OWNER)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+		if workspace.Personal {
+			utils.WriteError(w, r, utils.Forbidden("Personal workspaces can't be deleted"))
+			return
+		}
+
+		if _, err := utils.Exec(db, DELETE_WORKSPACE, id); err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Current resolves the workspace a request acts on and returns its id: the
+// one selected by WORKSPACE_HEADER, which the user must belong to, or else
+// their personal workspace. Queries on bookmarks, tags and collections are
+// scoped by that id, so nothing from other workspaces is ever visible.
+func Current(execer utils.Execer, r *http.Request, userId utils.UserId) (string, error) {
+	id := strings.TrimSpace(r.Header.Get(WORKSPACE_HEADER))
+	if id == "" {
+		return personalWorkspace(execer, userId)
+	}
+	if _, err := strconv.Atoi(id); err != nil {
+		return "", utils.BadRequest("Invalid workspace ID")
+	}
+
+	role, err := RoleOf(execer, id, userId)
+	if err != nil {
+		return "", err
+	}
+	if role == "" {
+		return "", utils.NotFound("Workspace not found")
+	}
+	return id, nil
+}
+
+// Allows tells whether holding granted is enough for something that needs required.
+func Allows(granted, required string) bool {
+	return slices.Index(roles, granted) >= slices.Index(roles, required)
+}
+
+// RoleOf returns the role a user holds in a workspace, or an empty string
+// when they don't belong to it.
+func RoleOf(execer utils.Execer, workspaceId string, userId utils.UserId) (string, error) {
+	stmt, err := execer.Prepare(WORKSPACE_ROLE)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	var role string
+	err = stmt.QueryRow(workspaceId, userId).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// Find loads a workspace the user holds at least the required role in.
+// Workspaces they don't belong to are reported as not found.
+func Find(execer utils.Execer, id string, userId utils.UserId, required string) (*Workspace, error) {
+	workspace, err := utils.FindOne(
+		func() (*sql.Row, error) {
+			stmt, err := execer.Prepare(`
+				SELECT ` + workspaceColumns + `
+				FROM workspaces w
+				JOIN workspace_members m ON m.workspace_id = w.id AND m.user_id = ?
+				WHERE w.id = ?`)
+			if err != nil {
+				return nil, err
+			}
+			return stmt.QueryRow(userId, id), nil
+		},
+		workspaceScanner,
+	)
+
+	var apiErr *utils.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == utils.NOT_FOUND {
+		return nil, utils.NotFound("Workspace not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !Allows(workspace.Role, required) {
+		return nil, utils.Forbidden(fmt.Sprintf("This requires the %s role in the workspace", required))
+	}
+	return workspace, nil
+}
+
+// personalWorkspace returns the id of the user's personal workspace, creating
+// it for users who registered since the last migration.
+func personalWorkspace(execer utils.Execer, userId utils.UserId) (string, error) {
+	id, err := findPersonal(execer, userId)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	if _, err := utils.Exec(execer, CREATE_PERSONAL, userId); err != nil {
+		return "", err
+	}
+	id, err = findPersonal(execer, userId)
+	if err == sql.ErrNoRows {
+		return "", utils.Unauthorized("Invalid token")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := utils.Exec(execer, ADD_MEMBER, id, userId, ROLE_OWNER); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func findPersonal(execer utils.Execer, userId utils.UserId) (string, error) {
+	stmt, err := execer.Prepare(FIND_PERSONAL)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	var id string
+	err = stmt.QueryRow(userId).Scan(&id)
+	return id, err
+}
+
+func validateName(name string) (string, utils.FieldErrors) {
+	errs := utils.FieldErrors{}
+
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		errs.Add("name", "Name is required")
+	}
+	if utf8.RuneCountInString(name) > MAX_WORKSPACE_NAME_LENGTH {
+		errs.Add("name", fmt.Sprintf("Name should be no longer than %d characters", MAX_WORKSPACE_NAME_LENGTH))
+	}
+
+	return name, errs
+}
+
+func validateRole(role string) error {
+	if !slices.Contains(roles, role) {
+		return fmt.Errorf("Role should be one of: %s", strings.Join(roles, ", "))
+	}
+	return nil
+}
+
+func workspaceScanner(row *sql.Row) (*Workspace, error) {
+	var workspace Workspace
+	if err := row.Scan(workspace.scanFields()...); err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+func workspacesScanner(rows *sql.Rows) ([]Workspace, error) {
+	result := []Workspace{}
+
+	for rows.Next() {
+		var workspace Workspace
+		if err := rows.Scan(workspace.scanFields()...); err != nil {
+			return nil, err
+		}
+		result = append(result, workspace)
+	}
+
+	return result, rows.Err()
+}
+
+func (w *Workspace) scanFields() []any {
+	return []any{
+		&w.Id,
+		&w.Name,
+		&w.Personal,
+		&w.CreatedBy,
+		&w.MemberCount,
+		&w.Role,
+		&w.CreatedAt,
+		&w.UpdatedAt,
+	}
+}